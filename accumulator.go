@@ -3,7 +3,49 @@ package utreexo
 import (
 	"encoding/hex"
 	"fmt"
+	"math/bits"
 	"sort"
+	"sync"
+)
+
+// Utreexo is the interface for an accumulator that is able to prove the leaves it
+// keeps along with verifying and applying modifications. Code that's written against
+// Utreexo isn't tied to a specific accumulator implementation.
+type Utreexo interface {
+	// Prove returns a proof of the passed in hashes.
+	Prove(hashes []Hash) (Proof, error)
+
+	// Verify verifies the proof of the delHashes against the roots.
+	Verify(delHashes []Hash, proof Proof) error
+
+	// Modify adds and deletes the leaves from the accumulator.
+	Modify(adds []Leaf, delHashes []Hash, origDels []uint64) error
+
+	// GetRoots returns the roots of the accumulator.
+	GetRoots() []Hash
+
+	// NumLeaves returns the number of all leaves ever added to the accumulator.
+	NumLeaves() uint64
+}
+
+// Verifier is the interface for an accumulator that only keeps the roots and isn't
+// able to prove anything. It's only able to verify proofs and update the roots.
+type Verifier interface {
+	// Verify verifies the proof of the delHashes against the roots.
+	Verify(delHashes []Hash, proof Proof) error
+
+	// Update verifies the proof and then applies the deletions and the
+	// additions to the roots.
+	Update(delHashes, addHashes []Hash, proof Proof) ([]Hash, error)
+
+	// GetRoots returns the roots of the accumulator.
+	GetRoots() []Hash
+}
+
+// Make sure the accumulators implement the interfaces.
+var (
+	_ Utreexo  = (*Pollard)(nil)
+	_ Verifier = (*Stump)(nil)
 )
 
 // Pollard is a representation of the utreexo forest using a collection of
@@ -37,28 +79,223 @@ type Pollard struct {
 	// Only Pollards that have the full value set to true will be able to prove all
 	// the elements.
 	full bool
+
+	// hasher is used to calculate the parent hashes.
+	hasher Hasher
+
+	// addWorkers is the number of goroutines used to hash the additions.
+	// The additions are hashed sequentially if it's less than 2.
+	addWorkers int
+
+	// addThreshold is the minimum number of additions needed for the additions
+	// to be hashed in parallel.
+	addThreshold int
+
+	// proofCache caches the proofs returned by Prove. Nil if proofs aren't cached.
+	proofCache *ProofCache
+
+	// leafMode is how the leaves commit to their data.
+	leafMode LeafMode
+
+	// leafData is the leaf data of the cached leaves that were added with
+	// AddLeafData. Only used in LeafModeMetadata.
+	leafData map[miniHash]LeafData
 }
 
 // NewAccumulator returns a initialized accumulator. To enable the generating proofs
 // for all elements, set full to true.
 func NewAccumulator(full bool) Pollard {
+	return NewAccumulatorWithHasher(full, DefaultHasher{})
+}
+
+// NewAccumulatorWithHasher returns an initialized accumulator that uses the given
+// hasher to calculate the parent hashes.
+func NewAccumulatorWithHasher(full bool, h Hasher) Pollard {
 	var p Pollard
 	p.nodeMap = make(map[miniHash]*polNode)
 	p.full = full
+	p.hasher = h
 
 	return p
 }
 
+// BlockUpdate is the modification that a single block makes to the pollard.
+type BlockUpdate struct {
+	// Adds are the leaves added in the block.
+	Adds []Leaf
+
+	// DelHashes are the hashes of the leaves deleted in the block.
+	DelHashes []Hash
+
+	// Proof is the proof for the DelHashes.
+	Proof Proof
+}
+
+// ModifyBatch applies the blocks to the pollard in order. The proof of each block
+// is verified before it's applied. If any of the blocks fail, the blocks that were
+// already applied are undone and the pollard is left as it was before the call.
+//
+// The buffers used for verifying the proofs and for sorting the deletions are
+// shared by all the blocks in the batch. The data needed to roll back the batch
+// references the blocks instead of copying them since it never leaves the call.
+func (p *Pollard) ModifyBatch(blocks []BlockUpdate) error {
+	undos := make([]UndoData, 0, len(blocks))
+
+	var scratch VerifyScratch
+	var dels []uint64
+
+	var err error
+	for i, block := range blocks {
+		err = p.verify(p.hasher, block.DelHashes, block.Proof, &scratch)
+		if err != nil {
+			err = fmt.Errorf("ModifyBatch fail at block %d. Error: %v", i, err)
+			break
+		}
+
+		undo := UndoData{
+			NumAdds:   uint64(len(block.Adds)),
+			Targets:   block.Proof.Targets,
+			DelHashes: block.DelHashes,
+			PrevRoots: p.GetRoots(),
+			LeafData:  p.deletedLeafData(block.DelHashes),
+		}
+		dels = append(dels[:0], block.Proof.Targets...)
+		err = p.modify(block.Adds, block.DelHashes, dels)
+		if err != nil {
+			err = fmt.Errorf("ModifyBatch fail at block %d. Error: %v", i, err)
+			break
+		}
+		undos = append(undos, undo)
+	}
+	if err == nil {
+		return nil
+	}
+
+	// Roll back the blocks that were applied.
+	for i := len(undos) - 1; i >= 0; i-- {
+		undoErr := p.Undo(undos[i].NumAdds, undos[i])
+		if undoErr != nil {
+			return fmt.Errorf("%v. Couldn't undo block %d. Error: %v", err, i, undoErr)
+		}
+	}
+
+	return err
+}
+
+// ApplyBlockAndReprove verifies the proof and applies the block to the pollard.
+// It then returns a proof for the retain hashes against the new roots. Any of
+// the adds that are in retain are remembered. The pollard is left untouched if
+// the proof is invalid.
+func (p *Pollard) ApplyBlockAndReprove(adds, delHashes []Hash, proof Proof,
+	retain []Hash) (Proof, error) {
+
+	err := p.Verify(delHashes, proof)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ApplyBlockAndReprove fail. Error: %w", err)
+	}
+
+	retainSet := make(map[Hash]struct{}, len(retain))
+	for _, hash := range retain {
+		retainSet[hash] = struct{}{}
+	}
+	leaves := make([]Leaf, len(adds))
+	for i, add := range adds {
+		_, found := retainSet[add]
+		leaves[i] = Leaf{Hash: add, Remember: found}
+	}
+
+	err = p.Modify(leaves, delHashes, proof.Targets)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ApplyBlockAndReprove fail. Error: %w", err)
+	}
+
+	retainProof, err := p.Prove(retain)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ApplyBlockAndReprove fail. Error: %w", err)
+	}
+
+	return retainProof, nil
+}
+
+// PredictRoots returns the roots that GetRoots would return after calling Modify with
+// the same additions and deletions. The proof is verified and the pollard is left
+// untouched. This lets the resulting roots be checked against a commitment before
+// the block is applied.
+func (p *Pollard) PredictRoots(addHashes, delHashes []Hash, proof Proof) ([]Hash, error) {
+	stump := p.ToStump()
+	_, err := stump.update(p.hasher, delHashes, addHashes, proof)
+	if err != nil {
+		return nil, fmt.Errorf("PredictRoots fail. Error: %w", err)
+	}
+
+	return stump.Roots, nil
+}
+
+// SetParallelAdd makes the pollard hash the additions with the given number of
+// workers when there are at least threshold additions. Setting workers to less
+// than 2 makes the pollard hash the additions sequentially.
+//
+// NOTE The hasher of the pollard must be safe for concurrent use when the
+// additions are hashed in parallel.
+func (p *Pollard) SetParallelAdd(workers, threshold int) {
+	p.addWorkers = workers
+	p.addThreshold = threshold
+}
+
+// SetLeafMode sets how the leaves of the pollard commit to their data. The mode
+// should be set before any leaves are added.
+func (p *Pollard) SetLeafMode(mode LeafMode) {
+	p.leafMode = mode
+}
+
+// AddLeafData adds the leaves to the accumulator. The leaf data of the leaves that
+// are cached is kept so that ProveLeafData is able to include their metadata in the
+// proofs. An error is returned if the pollard isn't in LeafModeMetadata.
+func (p *Pollard) AddLeafData(leaves []LeafData, remember bool) error {
+	if p.leafMode != LeafModeMetadata {
+		return fmt.Errorf("AddLeafData fail. Pollard isn't in LeafModeMetadata")
+	}
+	if p.leafData == nil {
+		p.leafData = make(map[miniHash]LeafData)
+	}
+
+	adds := make([]Leaf, len(leaves))
+	for i, leaf := range leaves {
+		adds[i] = leaf.Leaf(remember)
+		if remember || p.full {
+			p.leafData[adds[i].mini()] = LeafData{
+				Hash:     leaf.Hash,
+				Metadata: append([]byte(nil), leaf.Metadata...),
+			}
+		}
+	}
+
+	return p.Modify(adds, nil, nil)
+}
+
 // Modify takes in the additions and deletions and updates the accumulator accordingly.
 //
-// NOTE Modify does NOT do any validation and assumes that all the positions of the leaves
-// being deleted have already been verified.
+// NOTE Modify only checks that the positions of the leaves being deleted exist in the
+// accumulator. It assumes that the positions have already been verified.
 func (p *Pollard) Modify(adds []Leaf, delHashes []Hash, origDels []uint64) error {
 	// Make a copy to avoid mutating the deletion slice passed in.
-	delCount := len(origDels)
-	dels := make([]uint64, delCount)
+	dels := make([]uint64, len(origDels))
 	copy(dels, origDels)
 
+	return p.modify(adds, delHashes, dels)
+}
+
+// modify is Modify but sorts the dels in place.
+func (p *Pollard) modify(adds []Leaf, delHashes []Hash, dels []uint64) error {
+	forestRows := treeRows(p.numLeaves)
+	for i, del := range dels {
+		if !inForest(del, p.numLeaves, forestRows) {
+			return fmt.Errorf("Modify fail. %w. Target %d at index %d is out of "+
+				"range for %d leaves", ErrProofMalformed, del, i, p.numLeaves)
+		}
+	}
+	delCount := len(dels)
+
 	// Remove the delHashes from the map.
 	p.deleteFromMap(delHashes)
 
@@ -77,7 +314,7 @@ func (p *Pollard) Modify(adds []Leaf, delHashes []Hash, origDels []uint64) error
 func (p *Pollard) ModifyWithProof(adds []Leaf, delHashes []Hash, proof Proof) error {
 	err := p.Verify(delHashes, proof)
 	if err != nil {
-		return fmt.Errorf("ModifyWithProof fail. Error %s", err)
+		return fmt.Errorf("ModifyWithProof fail. Error: %w", err)
 	}
 
 	if len(delHashes) != 0 {
@@ -212,7 +449,7 @@ func (p *Pollard) updateNodes(beforeProof Proof) error {
 			// Check if the next prove is the sibling of this prove.
 			if i+1 < len(proves) && rightSib(prove.pos) == proves[i+1].pos {
 				nextProve := hashAndPos{
-					hash: parentHash(prove.hash, proves[i+1].hash),
+					hash: p.hasher.ParentHash(prove.hash, proves[i+1].hash),
 					pos:  parent(prove.pos, totalRows),
 				}
 				nextProves = append(nextProves, nextProve)
@@ -231,9 +468,9 @@ func (p *Pollard) updateNodes(beforeProof Proof) error {
 
 				nextProve := hashAndPos{pos: parent(prove.pos, totalRows)}
 				if isLeftNiece(prove.pos) {
-					nextProve.hash = parentHash(prove.hash, hash)
+					nextProve.hash = p.hasher.ParentHash(prove.hash, hash)
 				} else {
-					nextProve.hash = parentHash(hash, prove.hash)
+					nextProve.hash = p.hasher.ParentHash(hash, prove.hash)
 				}
 
 				if len(updateNodes) > 0 && sibling(prove.pos) == updateNodes[0].pos {
@@ -251,29 +488,112 @@ func (p *Pollard) updateNodes(beforeProof Proof) error {
 
 // add adds all the passed in leaves to the accumulator.
 func (p *Pollard) add(adds []Leaf) {
+	if p.addWorkers > 1 && len(adds) >= p.addThreshold {
+		p.addParallel(adds)
+		return
+	}
+
 	for _, add := range adds {
-		// Create a node from the hash. If the pollard is full, then remember
-		// every node.
-		node := &polNode{data: add.Hash, remember: add.Remember}
-		if p.full {
-			node.remember = true
+		p.addSingle(add, nil, 0)
+	}
+}
+
+// addSingle adds a single leaf to the pollard. If rows isn't nil, the parent
+// hashes are taken from rows instead of being calculated. idx is the index of
+// the leaf in rows[0].
+func (p *Pollard) addSingle(add Leaf, rows [][]Hash, idx uint64) {
+	// Create a node from the hash. If the pollard is full, then remember
+	// every node.
+	node := &polNode{data: add.Hash, remember: add.Remember}
+	if p.full {
+		node.remember = true
+	}
+
+	// Add the hash to the map if this node is supposed to be remembered.
+	if node.remember {
+		p.nodeMap[add.mini()] = node
+	}
+
+	newRoot := p.calculateNewRoot(node, rows, idx)
+	p.roots = append(p.roots, newRoot)
+
+	// Increment as we added a leaf.
+	p.numLeaves++
+}
+
+// addParallel adds the leaves to the pollard while hashing the perfect subtrees
+// made up of only the additions in parallel. The resulting pollard is the same
+// as when the leaves are added with addSingle.
+func (p *Pollard) addParallel(adds []Leaf) {
+	for len(adds) > 0 {
+		// Grab the biggest perfect subtree that can be made from the additions
+		// without touching any of the existing roots.
+		row := uint8(bits.Len64(uint64(len(adds))) - 1)
+		if p.numLeaves != 0 && uint8(bits.TrailingZeros64(p.numLeaves)) < row {
+			row = uint8(bits.TrailingZeros64(p.numLeaves))
 		}
+		chunk := adds[:1<<row]
+		adds = adds[1<<row:]
 
-		// Add the hash to the map if this node is supposed to be remembered.
-		if node.remember {
-			p.nodeMap[add.mini()] = node
+		rows := p.subtreeHashes(chunk)
+		for i, add := range chunk {
+			p.addSingle(add, rows, uint64(i))
 		}
+	}
+}
 
-		newRoot := p.calculateNewRoot(node)
-		p.roots = append(p.roots, newRoot)
+// subtreeHashes returns the hashes of every row of the perfect subtree made from
+// the given leaves. The amount of leaves must be a power of 2.
+func (p *Pollard) subtreeHashes(leaves []Leaf) [][]Hash {
+	rows := make([][]Hash, 0, bits.Len64(uint64(len(leaves))))
+	hashes := make([]Hash, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = leaf.Hash
+	}
+	rows = append(rows, hashes)
+
+	for len(hashes) > 1 {
+		children := hashes
+		hashes = make([]Hash, len(children)/2)
+
+		// Split up the hashes in this row between the workers.
+		perWorker := (len(hashes) + p.addWorkers - 1) / p.addWorkers
+		var wg sync.WaitGroup
+		for start := 0; start < len(hashes); start += perWorker {
+			end := start + perWorker
+			if end > len(hashes) {
+				end = len(hashes)
+			}
 
-		// Increment as we added a leaf.
-		p.numLeaves++
+			wg.Add(1)
+			go func(start, end int) {
+				defer wg.Done()
+				for i := start; i < end; i++ {
+					left, right := children[i*2], children[i*2+1]
+
+					// Match calculateNewRoot where an empty left node
+					// makes the right node move up.
+					if left == empty {
+						hashes[i] = right
+						continue
+					}
+					hashes[i] = p.hasher.ParentHash(left, right)
+				}
+			}(start, end)
+		}
+		wg.Wait()
+
+		rows = append(rows, hashes)
 	}
+
+	return rows
 }
 
 // calculateNewRoot adds the node to the accumulator and calculates the new root.
-func (p *Pollard) calculateNewRoot(node *polNode) *polNode {
+// calculateNewRoot adds the node to the roots and returns the new root. If rows
+// isn't nil, the parent hashes for the rows included in rows are taken from it
+// instead of being calculated. idx is the index of the node in rows[0].
+func (p *Pollard) calculateNewRoot(node *polNode, rows [][]Hash, idx uint64) *polNode {
 	// We can tell where the roots are by looking at the binary representation
 	// of the numLeaves. Wherever there's a 1, there's a root.
 	//
@@ -318,7 +638,12 @@ func (p *Pollard) calculateNewRoot(node *polNode) *polNode {
 		swapNieces(root, node)
 
 		// Calculate the hash of the new root.
-		nHash := parentHash(root.data, node.data)
+		var nHash Hash
+		if int(h)+1 < len(rows) {
+			nHash = rows[h+1][idx>>(h+1)]
+		} else {
+			nHash = p.hasher.ParentHash(root.data, node.data)
+		}
 
 		newRoot := &polNode{data: nHash, lNiece: root, rNiece: node}
 		if p.full {
@@ -463,7 +788,7 @@ func (p *Pollard) deleteSingle(del uint64) error {
 	}
 
 	// Hash this node and all the parents/ancestors of this node.
-	err = hashToRoot(parentNode)
+	err = hashToRoot(parentNode, p.hasher)
 	if err != nil {
 		return err
 	}
@@ -475,11 +800,66 @@ func (p *Pollard) deleteSingle(del uint64) error {
 func (p *Pollard) deleteFromMap(delHashes []Hash) {
 	for _, del := range delHashes {
 		delete(p.nodeMap, del.mini())
+		delete(p.leafData, del.mini())
 	}
 }
 
+// UndoData is the data needed to undo a modification to the accumulator.
+type UndoData struct {
+	// NumAdds is the count of the leaves that were added in the modification.
+	NumAdds uint64
+
+	// Targets are the positions of the leaves that were deleted.
+	Targets []uint64
+
+	// DelHashes are the hashes of the leaves that were deleted. They're in the
+	// same order as the Targets.
+	DelHashes []Hash
+
+	// PrevRoots are the roots before the modification.
+	PrevRoots []Hash
+
+	// LeafData is the leaf data of the deleted leaves that the pollard had kept
+	// in LeafModeMetadata. It's restored when the modification is undone.
+	LeafData []LeafData
+
+	// PostCommitment is the Commitment of the roots and the numLeaves after the
+	// modification. Stump.Undo checks it to make sure that the undo data is for
+	// the current state of the stump.
+	PostCommitment Hash
+}
+
+// ModifyWithUndo is Modify but it also returns the data needed to undo the
+// modification.
+func (p *Pollard) ModifyWithUndo(adds []Leaf, delHashes []Hash, origDels []uint64) (UndoData, error) {
+	// Copy the deletions to avoid keeping references to the slices passed in.
+	undo := UndoData{
+		NumAdds:   uint64(len(adds)),
+		Targets:   make([]uint64, len(origDels)),
+		DelHashes: make([]Hash, len(delHashes)),
+		PrevRoots: p.GetRoots(),
+		LeafData:  p.deletedLeafData(delHashes),
+	}
+	copy(undo.Targets, origDels)
+	copy(undo.DelHashes, delHashes)
+
+	err := p.Modify(adds, delHashes, origDels)
+	if err != nil {
+		return UndoData{}, err
+	}
+	undo.PostCommitment = Commitment(p.GetRoots(), p.numLeaves)
+
+	return undo, nil
+}
+
 // Undo reverts the most recent modify that happened to the accumulator.
-func (p *Pollard) Undo(numAdds uint64, dels []uint64, delHashes []Hash, prevRoots []Hash) error {
+func (p *Pollard) Undo(numAdds uint64, undo UndoData) error {
+	if numAdds != undo.NumAdds {
+		return fmt.Errorf("Undo fail. Was given %d adds but the undo data has %d adds",
+			numAdds, undo.NumAdds)
+	}
+	dels, delHashes, prevRoots := undo.Targets, undo.DelHashes, undo.PrevRoots
+
 	for i := 0; i < int(numAdds); i++ {
 		p.undoSingleAdd()
 	}
@@ -493,9 +873,32 @@ func (p *Pollard) Undo(numAdds uint64, dels []uint64, delHashes []Hash, prevRoot
 		return err
 	}
 
+	// Put back the leaf data of the deleted leaves.
+	if len(undo.LeafData) > 0 && p.leafData == nil {
+		p.leafData = make(map[miniHash]LeafData, len(undo.LeafData))
+	}
+	for _, leaf := range undo.LeafData {
+		p.leafData[leaf.LeafHash().mini()] = leaf
+	}
+
 	return nil
 }
 
+// deletedLeafData returns the leaf data that the pollard keeps for the delHashes.
+// The returned leaf data is what Undo needs to restore after the delHashes are
+// deleted.
+func (p *Pollard) deletedLeafData(delHashes []Hash) []LeafData {
+	var leaves []LeafData
+	for _, del := range delHashes {
+		leaf, found := p.leafData[del.mini()]
+		if found {
+			leaves = append(leaves, leaf)
+		}
+	}
+
+	return leaves
+}
+
 // undoEmptyRoots places empty roots back in after undoing the additions.
 func (p *Pollard) undoEmptyRoots(numAdds uint64, origDels []uint64, prevRoots []Hash) error {
 	if len(p.roots) >= int(numRoots(p.numLeaves)) {
@@ -590,7 +993,7 @@ func (p *Pollard) undoDels(dels []uint64, delHashes []Hash) error {
 	sort.Slice(pnps, func(a, b int) bool { return pnps[a].pos < pnps[b].pos })
 
 	totalRows := treeRows(p.numLeaves)
-	pnps = deTwinPolNode(pnps, totalRows)
+	pnps = deTwinPolNode(pnps, totalRows, p.hasher)
 
 	// Go through all the de-twined nodes and all from the highest position first.
 	for i := len(pnps) - 1; i >= 0; i-- {
@@ -626,7 +1029,7 @@ func (p *Pollard) undoSingleDel(node *polNode, pos uint64) error {
 			hex.EncodeToString(node.data[:]), pos, err)
 	}
 
-	pHash := calculateParentHash(pos, node, sibling)
+	pHash := calculateParentHash(pos, node, sibling, p.hasher)
 	parent := &polNode{data: pHash, remember: p.full}
 
 	// If the original parent of the deleted node is not a root.
@@ -676,7 +1079,7 @@ func (p *Pollard) undoSingleDel(node *polNode, pos uint64) error {
 		return nil
 	}
 
-	err = hashToRoot(parent)
+	err = hashToRoot(parent, p.hasher)
 	if err != nil {
 		return err
 	}
@@ -684,6 +1087,58 @@ func (p *Pollard) undoSingleDel(node *polNode, pos uint64) error {
 	return nil
 }
 
+// Clone returns a deep copy of the pollard. The returned pollard can be modified
+// without affecting the original pollard.
+func (p *Pollard) Clone() *Pollard {
+	clone := &Pollard{
+		nodeMap:   make(map[miniHash]*polNode, len(p.nodeMap)),
+		roots:     make([]*polNode, len(p.roots)),
+		numLeaves: p.numLeaves,
+		numDels:   p.numDels,
+		full:      p.full,
+		hasher:    p.hasher,
+
+		addWorkers:   p.addWorkers,
+		addThreshold: p.addThreshold,
+
+		leafMode: p.leafMode,
+	}
+	if p.leafData != nil {
+		clone.leafData = make(map[miniHash]LeafData, len(p.leafData))
+		for key, leaf := range p.leafData {
+			clone.leafData[key] = leaf
+		}
+	}
+
+	// Keep track of the copied nodes so that the node map can point to
+	// the copied nodes.
+	copied := make(map[*polNode]*polNode)
+	for i, root := range p.roots {
+		clone.roots[i] = cloneNode(root, nil, copied)
+	}
+
+	for key, node := range p.nodeMap {
+		clone.nodeMap[key] = copied[node]
+	}
+
+	return clone
+}
+
+// cloneNode copies the node and all its nieces. The copied node points to the
+// passed in aunt.
+func cloneNode(n, aunt *polNode, copied map[*polNode]*polNode) *polNode {
+	if n == nil {
+		return nil
+	}
+
+	newNode := &polNode{data: n.data, remember: n.remember, aunt: aunt}
+	newNode.lNiece = cloneNode(n.lNiece, newNode, copied)
+	newNode.rNiece = cloneNode(n.rNiece, newNode, copied)
+	copied[n] = newNode
+
+	return newNode
+}
+
 // GetRoots returns the hashes of all the roots.
 func (p *Pollard) GetRoots() []Hash {
 	roots := make([]Hash, 0, len(p.roots))
@@ -695,6 +1150,195 @@ func (p *Pollard) GetRoots() []Hash {
 	return roots
 }
 
+// NumLeaves returns the number of all leaves that were ever added to the accumulator.
+// Deleted leaves are included in the count.
+func (p *Pollard) NumLeaves() uint64 {
+	return p.numLeaves
+}
+
+// NumRoots returns the number of roots in the accumulator. Roots that are empty
+// because all of their leaves were deleted are included in the count.
+func (p *Pollard) NumRoots() int {
+	return len(p.roots)
+}
+
+// HasLeaf returns true if the leaf is cached in the pollard.
+//
+// NOTE A false result only means that the leaf isn't in the accumulator if the
+// pollard is full. Otherwise the leaf may just not be cached.
+func (p *Pollard) HasLeaf(hash Hash) bool {
+	node, found := p.nodeMap[hash.mini()]
+	return found && node.data == hash
+}
+
+// ProveHashExists returns true if the pollard is able to prove that the leaf is
+// in the accumulator. The proof is generated and verified against the roots.
+//
+// NOTE A false result only means that the leaf isn't in the accumulator if the
+// pollard is full.
+func (p *Pollard) ProveHashExists(hash Hash) bool {
+	if !p.HasLeaf(hash) {
+		return false
+	}
+
+	proof, err := p.ProveSingle(hash)
+	if err != nil {
+		return false
+	}
+
+	return p.Verify([]Hash{hash}, proof) == nil
+}
+
+// ProvedLeaves returns the hashes of all the leaves cached in the pollard, ordered
+// by their positions. For a full pollard, these are all the leaves in the
+// accumulator so a hash not included is not in the accumulator.
+func (p *Pollard) ProvedLeaves() []Hash {
+	leaves := make([]hashAndPos, 0, len(p.nodeMap))
+	for _, node := range p.nodeMap {
+		leaves = append(leaves, hashAndPos{node.data, p.calculatePosition(node)})
+	}
+	sort.Slice(leaves, func(a, b int) bool { return leaves[a].pos < leaves[b].pos })
+
+	hashes := make([]Hash, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = leaf.hash
+	}
+
+	return hashes
+}
+
+// Prune forgets the given leaves along with all the nodes that are no longer
+// needed to prove the leaves that are still cached. If the pollard is full, it
+// stops being full as it no longer holds all the leaves.
+func (p *Pollard) Prune(hashes []Hash) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	// Resolve all the nodes before modifying the pollard so that a hash that isn't
+	// cached leaves the pollard untouched.
+	nodes := make([]*polNode, 0, len(hashes))
+	seen := make(map[miniHash]struct{}, len(hashes))
+	for _, hash := range hashes {
+		node, found := p.nodeMap[hash.mini()]
+		if !found || node.data != hash {
+			return fmt.Errorf("Pollard.Prune fail. %w: %s",
+				ErrHashNotFound, hex.EncodeToString(hash[:]))
+		}
+		if _, found := seen[hash.mini()]; found {
+			continue
+		}
+		seen[hash.mini()] = struct{}{}
+		nodes = append(nodes, node)
+	}
+
+	// Full pollards remember every node. Only the cached leaves should be
+	// remembered once some leaves are pruned.
+	if p.full {
+		for _, root := range p.roots {
+			p.forgetNonLeaves(root)
+		}
+		p.full = false
+	}
+
+	forestRows := treeRows(p.numLeaves)
+	for _, node := range nodes {
+		node.remember = false
+		delete(p.nodeMap, node.data.mini())
+		delete(p.leafData, node.data.mini())
+
+		// Go up the tree and remove the node and its sibling as long as both
+		// aren't remembered and there's nothing below them. The node at pos is
+		// only there to prove what's below its sibling and vice versa.
+		pos := p.calculatePosition(node)
+		for !isRootPosition(pos, p.numLeaves, forestRows) {
+			n, sib, _, err := p.getNode(pos)
+			if err != nil {
+				return fmt.Errorf("Pollard.Prune fail. Error: %v", err)
+			}
+			if n == nil || n.remember || !n.deadEnd() {
+				break
+			}
+			if sib != nil && (sib.remember || !sib.deadEnd()) {
+				break
+			}
+
+			delNode(n)
+			delNode(sib)
+			pos = parent(pos, forestRows)
+		}
+	}
+
+	return nil
+}
+
+// forgetNonLeaves sets remember to false for all the nodes below n that aren't
+// leaves cached in the nodeMap.
+func (p *Pollard) forgetNonLeaves(n *polNode) {
+	if n == nil {
+		return
+	}
+	if p.nodeMap[n.data.mini()] != n {
+		n.remember = false
+	}
+	p.forgetNonLeaves(n.lNiece)
+	p.forgetNonLeaves(n.rNiece)
+}
+
+// ToStump returns the current roots and the numLeaves of the pollard as a Stump.
+func (p *Pollard) ToStump() Stump {
+	return Stump{Roots: p.GetRoots(), NumLeaves: p.numLeaves}
+}
+
+// GetRootsWithPositions returns the hashes of all the roots along with their
+// positions. The roots are ordered the same as GetRoots and positions[i] is the
+// position of roots[i].
+func (p *Pollard) GetRootsWithPositions() ([]Hash, []uint64) {
+	return p.GetRoots(), RootPositions(p.numLeaves, treeRows(p.numLeaves))
+}
+
+// RootRange is a root and the range of leaf positions that are under it. The range
+// is inclusive of both FirstLeaf and LastLeaf. Use EndLeaf for the exclusive end of
+// the range when a half-open [FirstLeaf, EndLeaf) range is needed.
+type RootRange struct {
+	// Root is the hash of the root.
+	Root Hash
+
+	// FirstLeaf is the position of the leftmost leaf under the root.
+	FirstLeaf uint64
+
+	// LastLeaf is the position of the rightmost leaf under the root.
+	LastLeaf uint64
+}
+
+// EndLeaf returns the position right after the rightmost leaf under the root. It's
+// the exclusive end of the range.
+func (r RootRange) EndLeaf() uint64 {
+	return r.LastLeaf + 1
+}
+
+// RootRanges returns each root along with the range of the leaf positions that
+// the root covers. The roots are ordered from the highest root to the lowest root,
+// same as GetRoots.
+func (p *Pollard) RootRanges() []RootRange {
+	sizes := SubtreeSizes(p.numLeaves)
+	ranges := make([]RootRange, 0, len(sizes))
+
+	// The roots are ordered from the highest row to the lowest row and each
+	// of them covers the leaves right after the leaves of the previous root.
+	firstLeaf := uint64(0)
+	for i, size := range sizes {
+		ranges = append(ranges, RootRange{
+			Root:      p.roots[i].data,
+			FirstLeaf: firstLeaf,
+			LastLeaf:  firstLeaf + size - 1,
+		})
+		firstLeaf += size
+	}
+
+	return ranges
+}
+
 // GetTotalCount returns the count of all the polNodes in the pollard.
 func (p *Pollard) GetTotalCount() int64 {
 	var size int64
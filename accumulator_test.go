@@ -1,12 +1,16 @@
 package utreexo
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/rand"
 	"reflect"
 	"testing"
+
+	"golang.org/x/exp/slices"
 )
 
 func (p *Pollard) posMapSanity() error {
@@ -200,7 +204,12 @@ func TestUndo(t *testing.T) {
 		}
 
 		// Perform the undo.
-		err = p.Undo(uint64(len(test.modifyAdds)), modifyProof.Targets, test.modifyDels, beforeRoots)
+		err = p.Undo(uint64(len(test.modifyAdds)), UndoData{
+			NumAdds:   uint64(len(test.modifyAdds)),
+			Targets:   modifyProof.Targets,
+			DelHashes: test.modifyDels,
+			PrevRoots: beforeRoots,
+		})
 		if err != nil {
 			err := fmt.Errorf("TestUndo failed %d: error %v"+
 				"\nbefore:\n\n%s"+
@@ -267,7 +276,7 @@ func checkHashes(node, sibling *polNode, p *Pollard) error {
 	// If node has a niece, then we can calculate the hash of the sibling because
 	// every tree is a perfect binary tree.
 	if node.lNiece != nil {
-		calculated := parentHash(node.lNiece.data, node.rNiece.data)
+		calculated := p.hasher.ParentHash(node.lNiece.data, node.rNiece.data)
 		if sibling.data != calculated {
 			return fmt.Errorf("For position %d, calculated %s from left %s, right %s but read %s",
 				p.calculatePosition(sibling),
@@ -283,7 +292,7 @@ func checkHashes(node, sibling *polNode, p *Pollard) error {
 	}
 
 	if sibling.lNiece != nil {
-		calculated := parentHash(sibling.lNiece.data, sibling.rNiece.data)
+		calculated := p.hasher.ParentHash(sibling.lNiece.data, sibling.rNiece.data)
 		if node.data != calculated {
 			return fmt.Errorf("For position %d, calculated %s from left %s, right %s but read %s",
 				p.calculatePosition(node),
@@ -307,7 +316,7 @@ func (p *Pollard) checkHashes() error {
 	for _, root := range p.roots {
 		if root.lNiece != nil && root.rNiece != nil {
 			// First check the root hash.
-			calculatedHash := parentHash(root.lNiece.data, root.rNiece.data)
+			calculatedHash := p.hasher.ParentHash(root.lNiece.data, root.rNiece.data)
 			if calculatedHash != root.data {
 				err := fmt.Errorf("For position %d, calculated %s from left %s, right %s but read %s",
 					p.calculatePosition(root),
@@ -756,14 +765,14 @@ func FuzzUndo(f *testing.F) {
 		}
 
 		modifyLeaves, _, _ := getAddsAndDels(uint32(p.numLeaves), uint32(modifyAdds), 0)
-		err = p.Modify(modifyLeaves, dels, bp.Targets)
+		undo, err := p.ModifyWithUndo(modifyLeaves, dels, bp.Targets)
 		if err != nil {
 			t.Fatal(err)
 		}
 		afterStr := p.String()
 		afterMap := nodeMapToString(p.nodeMap)
 
-		err = p.Undo(uint64(modifyAdds), bp.Targets, dels, beforeRoots)
+		err = p.Undo(uint64(modifyAdds), undo)
 		if err != nil {
 			startHashes := make([]Hash, len(leaves))
 			for i, leaf := range leaves {
@@ -972,7 +981,12 @@ func FuzzUndoChain(f *testing.F) {
 
 			// Undo the last modify.
 			if b%3 == 2 {
-				err := p.Undo(uint64(len(adds)), undoTargs, undoDelHashes, beforeRoot)
+				err := p.Undo(uint64(len(adds)), UndoData{
+					NumAdds:   uint64(len(adds)),
+					Targets:   undoTargs,
+					DelHashes: undoDelHashes,
+					PrevRoots: beforeRoot,
+				})
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -1246,3 +1260,769 @@ func checkCachedPolNodeHashes(p *Pollard) error {
 //		fmt.Println("p", p.String())
 //	})
 //}
+
+func TestPollardClone(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 20; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestPollardClone fail at block %d. Error: %v", b, err)
+		}
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestPollardClone fail at block %d. Error: %v", b, err)
+		}
+	}
+
+	// Grab the leaves to prove before the clone is modified.
+	leaves := make([]Hash, 0, len(p.nodeMap))
+	for _, node := range p.nodeMap {
+		leaves = append(leaves, node.data)
+	}
+	beforeRoots := p.GetRoots()
+	beforeProof, err := p.Prove(leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone := p.Clone()
+	err = clone.posMapSanity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Modify the clone.
+	adds, _, delHashes := sc.NextBlock(5)
+	proof, err := clone.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clone.Modify(adds, delHashes, proof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clone.checkHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clone.posMapSanity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The original should not have changed.
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) {
+		t.Fatalf("TestPollardClone fail. Roots of the original changed after "+
+			"modifying the clone.\nBefore:\n%s\nAfter:\n%s",
+			printHashes(beforeRoots), printHashes(p.GetRoots()))
+	}
+	afterProof, err := p.Prove(leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(beforeProof, afterProof) {
+		t.Fatalf("TestPollardClone fail. Proof from the original changed after " +
+			"modifying the clone.")
+	}
+	err = p.posMapSanity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The clone should match the original after the same modification.
+	err = p.Modify(adds, delHashes, proof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.GetRoots(), clone.GetRoots()) {
+		t.Fatalf("TestPollardClone fail. Roots of the clone and the original differ."+
+			"\nOriginal:\n%s\nClone:\n%s",
+			printHashes(p.GetRoots()), printHashes(clone.GetRoots()))
+	}
+}
+
+func TestRootRanges(t *testing.T) {
+	t.Parallel()
+
+	for numLeaves := uint32(0); numLeaves < 70; numLeaves++ {
+		p := NewAccumulator(true)
+		adds, _, _ := getAddsAndDels(0, numLeaves, 0)
+		err := p.Modify(adds, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ranges := p.RootRanges()
+		if len(ranges) != len(p.roots) {
+			t.Fatalf("TestRootRanges fail. Expected %d ranges, got %d",
+				len(p.roots), len(ranges))
+		}
+
+		// The ranges should cover all the leaves without any gaps or overlaps.
+		next := uint64(0)
+		forestRows := treeRows(p.numLeaves)
+		sizes := SubtreeSizes(p.numLeaves)
+		for i, r := range ranges {
+			if r.FirstLeaf != next {
+				t.Fatalf("TestRootRanges fail for %d leaves. Expected range %d "+
+					"to start at %d, got %d", numLeaves, i, next, r.FirstLeaf)
+			}
+			if r.EndLeaf()-r.FirstLeaf != sizes[i] {
+				t.Fatalf("TestRootRanges fail for %d leaves. Expected range %d "+
+					"to cover %d leaves, got %d", numLeaves, i, sizes[i],
+					r.EndLeaf()-r.FirstLeaf)
+			}
+			if r.Root != p.roots[i].data {
+				t.Fatalf("TestRootRanges fail for %d leaves. Wrong root for range %d",
+					numLeaves, i)
+			}
+
+			// Every leaf in the range should be under the root.
+			for leaf := r.FirstLeaf; leaf <= r.LastLeaf; leaf++ {
+				rootPos, err := getRootPosition(leaf, p.numLeaves, forestRows)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.getHash(rootPos) != r.Root {
+					t.Fatalf("TestRootRanges fail for %d leaves. Leaf %d isn't "+
+						"under root %d", numLeaves, leaf, i)
+				}
+			}
+			next = r.EndLeaf()
+		}
+		if next != p.numLeaves {
+			t.Fatalf("TestRootRanges fail. Ranges cover %d leaves but have %d leaves",
+				next, p.numLeaves)
+		}
+	}
+}
+
+// sha256Hasher is a Hasher that uses SHA256 instead of SHA512/256.
+type sha256Hasher struct{}
+
+func (sha256Hasher) ParentHash(left, right Hash) Hash {
+	return sha256.Sum256(append(left[:], right[:]...))
+}
+
+func TestNewAccumulatorWithHasher(t *testing.T) {
+	t.Parallel()
+
+	var hasher sha256Hasher
+	p := NewAccumulatorWithHasher(true, hasher)
+	defaultP := NewAccumulator(true)
+
+	leaves := make([]Leaf, 4)
+	for i := range leaves {
+		leaves[i] = Leaf{Hash: Hash{uint8(i + 1)}}
+	}
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = defaultP.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := hasher.ParentHash(
+		hasher.ParentHash(leaves[0].Hash, leaves[1].Hash),
+		hasher.ParentHash(leaves[2].Hash, leaves[3].Hash))
+	if p.GetRoots()[0] != expected {
+		t.Fatalf("TestNewAccumulatorWithHasher fail. Expected root %s, got %s",
+			hex.EncodeToString(expected[:]), hex.EncodeToString(p.GetRoots()[0][:]))
+	}
+	if p.GetRoots()[0] == defaultP.GetRoots()[0] {
+		t.Fatalf("TestNewAccumulatorWithHasher fail. Expected the roots to differ " +
+			"from the roots calculated with the default hasher")
+	}
+
+	// Run a chain to make sure proving, verifying and modifying all use the
+	// configured hasher.
+	sc := newSimChainWithSeed(0x07, 0)
+	for b := 0; b <= 20; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestNewAccumulatorWithHasher fail at block %d. Error: %v", b, err)
+		}
+		err = p.Verify(delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestNewAccumulatorWithHasher fail at block %d. Error: %v", b, err)
+		}
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestNewAccumulatorWithHasher fail at block %d. Error: %v", b, err)
+		}
+	}
+
+	clone := p.Clone()
+	if clone.hasher != p.hasher {
+		t.Fatalf("TestNewAccumulatorWithHasher fail. Clone didn't copy the hasher")
+	}
+}
+
+func TestParallelAdd(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	seq := NewAccumulator(true)
+	par := NewAccumulator(true)
+	par.SetParallelAdd(4, 2)
+
+	for b := 0; b <= 50; b++ {
+		adds, _, delHashes := sc.NextBlock(uint32(rand.Intn(300)))
+		proof, err := seq.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestParallelAdd fail at block %d. Error: %v", b, err)
+		}
+
+		err = seq.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestParallelAdd fail at block %d. Error: %v", b, err)
+		}
+		err = par.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestParallelAdd fail at block %d. Error: %v", b, err)
+		}
+
+		if !reflect.DeepEqual(seq.GetRoots(), par.GetRoots()) {
+			t.Fatalf("TestParallelAdd fail at block %d. Roots differ."+
+				"\nSequential:\n%s\nParallel:\n%s", b,
+				printHashes(seq.GetRoots()), printHashes(par.GetRoots()))
+		}
+		err = par.checkHashes()
+		if err != nil {
+			t.Fatalf("TestParallelAdd fail at block %d. Error: %v", b, err)
+		}
+		err = par.posMapSanity()
+		if err != nil {
+			t.Fatalf("TestParallelAdd fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	leaves, _, _ := getAddsAndDels(0, 1<<14, 0)
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := NewAccumulator(true)
+				p.SetParallelAdd(workers, 1024)
+				err := p.Modify(leaves, nil, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestToStump(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestToStump fail at block %d. Error: %v", b, err)
+		}
+
+		stump := p.ToStump()
+		if stump.NumLeaves != p.numLeaves {
+			t.Fatalf("TestToStump fail at block %d. Expected %d leaves, got %d",
+				b, p.numLeaves, stump.NumLeaves)
+		}
+		_, err = StumpVerify(stump, delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestToStump fail at block %d. Error: %v", b, err)
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestToStump fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func TestHasLeaf(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	p := NewAccumulator(true)
+
+	// All the leaves currently in the accumulator.
+	current := make(map[Hash]struct{})
+	var deleted []Hash
+	for b := 0; b <= 20; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestHasLeaf fail at block %d. Error: %v", b, err)
+		}
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestHasLeaf fail at block %d. Error: %v", b, err)
+		}
+
+		for _, add := range adds {
+			current[add.Hash] = struct{}{}
+		}
+		for _, del := range delHashes {
+			delete(current, del)
+			deleted = append(deleted, del)
+		}
+	}
+
+	for hash := range current {
+		if !p.HasLeaf(hash) || !p.ProveHashExists(hash) {
+			t.Fatalf("TestHasLeaf fail. Expected %s to exist", hash)
+		}
+	}
+	for _, hash := range deleted {
+		if p.HasLeaf(hash) || p.ProveHashExists(hash) {
+			t.Fatalf("TestHasLeaf fail. Expected deleted %s to not exist", hash)
+		}
+	}
+
+	leaves := p.ProvedLeaves()
+	if len(leaves) != len(current) {
+		t.Fatalf("TestHasLeaf fail. Expected %d leaves, got %d", len(current), len(leaves))
+	}
+	var prevPos uint64
+	for i, leaf := range leaves {
+		if _, found := current[leaf]; !found {
+			t.Fatalf("TestHasLeaf fail. Leaf %s isn't in the accumulator", leaf)
+		}
+		pos := p.calculatePosition(p.nodeMap[leaf.mini()])
+		if i > 0 && pos <= prevPos {
+			t.Fatalf("TestHasLeaf fail. Leaves not ordered by position")
+		}
+		prevPos = pos
+	}
+}
+
+func TestApplyBlockAndReprove(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	p := NewAccumulator(true)
+
+	// Retain some of the leaves that are added and stop retaining them once
+	// they're deleted.
+	var retain []Hash
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestApplyBlockAndReprove fail at block %d. Error: %v", b, err)
+		}
+
+		addHashes := make([]Hash, len(adds))
+		for i := range addHashes {
+			addHashes[i] = adds[i].Hash
+		}
+
+		kept := retain[:0]
+		for _, hash := range retain {
+			if !slices.Contains(delHashes, hash) {
+				kept = append(kept, hash)
+			}
+		}
+		retain = kept
+		if len(addHashes) > 0 {
+			retain = append(retain, addHashes[0])
+		}
+
+		retainProof, err := p.ApplyBlockAndReprove(addHashes, delHashes, proof, retain)
+		if err != nil {
+			t.Fatalf("TestApplyBlockAndReprove fail at block %d. Error: %v", b, err)
+		}
+		_, err = StumpVerify(p.ToStump(), retain, retainProof)
+		if err != nil {
+			t.Fatalf("TestApplyBlockAndReprove fail at block %d. Error: %v", b, err)
+		}
+	}
+
+	// An invalid proof shouldn't modify the pollard.
+	before := p.GetRoots()
+	proof, err := p.Prove(retain[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.ApplyBlockAndReprove([]Hash{{1}}, []Hash{{2}}, proof, nil)
+	if err == nil {
+		t.Fatalf("TestApplyBlockAndReprove fail. Expected an error for an invalid proof")
+	}
+	if !reflect.DeepEqual(before, p.GetRoots()) {
+		t.Fatalf("TestApplyBlockAndReprove fail. Roots modified after an invalid proof")
+	}
+}
+
+func TestGetRootsWithPositions(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestGetRootsWithPositions fail at block %d. Error: %v", b, err)
+		}
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestGetRootsWithPositions fail at block %d. Error: %v", b, err)
+		}
+
+		roots, positions := p.GetRootsWithPositions()
+		if !reflect.DeepEqual(roots, p.GetRoots()) {
+			t.Fatalf("TestGetRootsWithPositions fail at block %d. Roots differ "+
+				"from GetRoots", b)
+		}
+		if len(roots) != len(positions) {
+			t.Fatalf("TestGetRootsWithPositions fail at block %d. Got %d roots "+
+				"but %d positions", b, len(roots), len(positions))
+		}
+
+		forestRows := treeRows(p.numLeaves)
+		for i, pos := range positions {
+			if !isRootPosition(pos, p.numLeaves, forestRows) {
+				t.Fatalf("TestGetRootsWithPositions fail at block %d. %d isn't "+
+					"a root position", b, pos)
+			}
+			if i > 0 && detectRow(pos, forestRows) >= detectRow(positions[i-1], forestRows) {
+				t.Fatalf("TestGetRootsWithPositions fail at block %d. Positions "+
+					"%v not ordered from the highest root", b, positions)
+			}
+			if roots[i] != empty && p.getHash(pos) != roots[i] {
+				t.Fatalf("TestGetRootsWithPositions fail at block %d. Root at "+
+					"position %d doesn't match", b, pos)
+			}
+		}
+	}
+}
+
+func TestModifyBatch(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	p := NewAccumulator(true)
+	batched := NewAccumulator(true)
+
+	for batch := 0; batch < 5; batch++ {
+		blocks := make([]BlockUpdate, 0, 10)
+		for b := 0; b < 10; b++ {
+			adds, _, delHashes := sc.NextBlock(5)
+			proof, err := p.Prove(delHashes)
+			if err != nil {
+				t.Fatalf("TestModifyBatch fail at batch %d. Error: %v", batch, err)
+			}
+			err = p.Modify(adds, delHashes, proof.Targets)
+			if err != nil {
+				t.Fatalf("TestModifyBatch fail at batch %d. Error: %v", batch, err)
+			}
+			blocks = append(blocks, BlockUpdate{adds, delHashes, proof})
+		}
+
+		// Corrupt a block in the middle of the batch. Nothing in the batch
+		// should be applied.
+		before := batched.GetRoots()
+		bad := make([]BlockUpdate, len(blocks))
+		copy(bad, blocks)
+		for i := len(bad) / 2; i < len(bad); i++ {
+			if len(bad[i].DelHashes) == 0 {
+				continue
+			}
+			bad[i].DelHashes = append([]Hash{{1}}, bad[i].DelHashes[1:]...)
+			break
+		}
+		err := batched.ModifyBatch(bad)
+		if err == nil {
+			t.Fatalf("TestModifyBatch fail at batch %d. Expected an error", batch)
+		}
+		if !reflect.DeepEqual(before, batched.GetRoots()) {
+			t.Fatalf("TestModifyBatch fail at batch %d. Roots changed after "+
+				"a failed batch", batch)
+		}
+		err = batched.posMapSanity()
+		if err != nil {
+			t.Fatalf("TestModifyBatch fail at batch %d. Error: %v", batch, err)
+		}
+
+		targets := make([][]uint64, len(blocks))
+		for i := range blocks {
+			targets[i] = append([]uint64(nil), blocks[i].Proof.Targets...)
+		}
+		err = batched.ModifyBatch(blocks)
+		if err != nil {
+			t.Fatalf("TestModifyBatch fail at batch %d. Error: %v", batch, err)
+		}
+		for i := range blocks {
+			if !slices.Equal(targets[i], blocks[i].Proof.Targets) {
+				t.Fatalf("TestModifyBatch fail at batch %d. The targets of "+
+					"block %d were modified", batch, i)
+			}
+		}
+		if !reflect.DeepEqual(p.GetRoots(), batched.GetRoots()) {
+			t.Fatalf("TestModifyBatch fail at batch %d. Expected roots:\n%s\ngot:\n%s",
+				batch, printHashes(p.GetRoots()), printHashes(batched.GetRoots()))
+		}
+	}
+}
+
+func TestNumLeavesAndRoots(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestNumLeavesAndRoots fail at block %d. Error: %v", b, err)
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestNumLeavesAndRoots fail at block %d. Error: %v", b, err)
+		}
+
+		if p.NumLeaves() != p.numLeaves {
+			t.Fatalf("TestNumLeavesAndRoots fail at block %d. Expected %d leaves, got %d",
+				b, p.numLeaves, p.NumLeaves())
+		}
+		if p.NumRoots() != len(p.GetRoots()) || p.NumRoots() != int(numRoots(p.numLeaves)) {
+			t.Fatalf("TestNumLeavesAndRoots fail at block %d. Expected %d roots, got %d",
+				b, numRoots(p.numLeaves), p.NumRoots())
+		}
+	}
+}
+
+func TestPredictRoots(t *testing.T) {
+	t.Parallel()
+
+	for _, hasher := range []Hasher{DefaultHasher{}, sha256Hasher{}} {
+		sc := newSimChainWithSeed(0x07, 0)
+		p := NewAccumulatorWithHasher(true, hasher)
+		for b := 0; b <= 30; b++ {
+			adds, _, delHashes := sc.NextBlock(5)
+			proof, err := p.Prove(delHashes)
+			if err != nil {
+				t.Fatalf("TestPredictRoots fail at block %d. Error: %v", b, err)
+			}
+
+			addHashes := make([]Hash, len(adds))
+			for i := range adds {
+				addHashes[i] = adds[i].Hash
+			}
+			before := p.GetRoots()
+			predicted, err := p.PredictRoots(addHashes, delHashes, proof)
+			if err != nil {
+				t.Fatalf("TestPredictRoots fail at block %d. Error: %v", b, err)
+			}
+			if !reflect.DeepEqual(before, p.GetRoots()) {
+				t.Fatalf("TestPredictRoots fail at block %d. The pollard was modified", b)
+			}
+
+			err = p.Modify(adds, delHashes, proof.Targets)
+			if err != nil {
+				t.Fatalf("TestPredictRoots fail at block %d. Error: %v", b, err)
+			}
+			if !reflect.DeepEqual(predicted, p.GetRoots()) {
+				t.Fatalf("TestPredictRoots fail at block %d. Expected roots:\n%s\ngot:\n%s",
+					b, printHashes(p.GetRoots()), printHashes(predicted))
+			}
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	for _, numAdds := range []uint32{1, 2, 7, 8, 27, 64} {
+		full := NewAccumulator(true)
+		leaves, _, _ := getAddsAndDels(uint32(full.numLeaves), numAdds, 0)
+		err := full.Modify(leaves, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Keep every third leaf and prune the rest.
+		var kept, prunedHashes []Hash
+		for i, leaf := range leaves {
+			if i%3 == 0 {
+				kept = append(kept, leaf.Hash)
+			} else {
+				prunedHashes = append(prunedHashes, leaf.Hash)
+			}
+		}
+
+		// Only the kept leaves, the roots, and the nodes needed to prove the
+		// kept leaves should be left after pruning.
+		forestRows := treeRows(full.numLeaves)
+		keptPositions, err := full.TargetsForHashes(kept)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofPos, computable := ProofPositions(keptPositions, full.numLeaves, forestRows)
+		needed := make(map[uint64]struct{})
+		for _, positions := range [][]uint64{keptPositions, proofPos, computable,
+			RootPositions(full.numLeaves, forestRows)} {
+			for _, pos := range positions {
+				needed[pos] = struct{}{}
+			}
+		}
+
+		err = full.Prune(prunedHashes)
+		if err != nil {
+			t.Fatalf("TestPrune fail. Error: %v", err)
+		}
+		err = full.posMapSanity()
+		if err != nil {
+			t.Fatalf("TestPrune fail. Error: %v", err)
+		}
+
+		for _, hash := range prunedHashes {
+			if full.HasLeaf(hash) {
+				t.Fatalf("TestPrune fail. Pruned leaf %s is still cached",
+					hex.EncodeToString(hash[:]))
+			}
+		}
+		if full.GetTotalCount() != int64(len(needed)) {
+			t.Fatalf("TestPrune fail. Expected %d nodes after pruning, got %d\n%s",
+				len(needed), full.GetTotalCount(), full.String())
+		}
+
+		proof, err := full.Prove(kept)
+		if err != nil {
+			t.Fatalf("TestPrune fail. Error: %v", err)
+		}
+		err = full.Verify(kept, proof)
+		if err != nil {
+			t.Fatalf("TestPrune fail. Error: %v", err)
+		}
+
+		// Pruning a leaf that isn't cached should fail.
+		if len(prunedHashes) > 0 {
+			err = full.Prune(prunedHashes[:1])
+			if !errors.Is(err, ErrHashNotFound) {
+				t.Fatalf("TestPrune fail. Expected %v, got %v", ErrHashNotFound, err)
+			}
+		}
+
+		// Pruning everything should leave only the roots.
+		err = full.Prune(kept)
+		if err != nil {
+			t.Fatalf("TestPrune fail. Error: %v", err)
+		}
+		if len(full.nodeMap) != 0 {
+			t.Fatalf("TestPrune fail. Expected empty nodeMap, got %d", len(full.nodeMap))
+		}
+		if full.GetTotalCount() != int64(len(full.roots)) {
+			t.Fatalf("TestPrune fail. Expected only the %d roots, got %d nodes",
+				len(full.roots), full.GetTotalCount())
+		}
+	}
+}
+
+func TestPruneFailUnchanged(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 15, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeStr := p.String()
+	beforeCount := p.GetTotalCount()
+	beforeMapLen := len(p.nodeMap)
+
+	// The last hash isn't in the accumulator so none of the hashes should be pruned.
+	hashes := []Hash{leaves[0].Hash, leaves[5].Hash, {0xde, 0xad}}
+	err = p.Prune(hashes)
+	if !errors.Is(err, ErrHashNotFound) {
+		t.Fatalf("TestPruneFailUnchanged fail. Expected %v, got %v", ErrHashNotFound, err)
+	}
+
+	if !p.full {
+		t.Fatalf("TestPruneFailUnchanged fail. Expected the pollard to still be full")
+	}
+	if p.GetTotalCount() != beforeCount || len(p.nodeMap) != beforeMapLen {
+		t.Fatalf("TestPruneFailUnchanged fail. Expected %d nodes and %d cached "+
+			"leaves, got %d and %d", beforeCount, beforeMapLen,
+			p.GetTotalCount(), len(p.nodeMap))
+	}
+	if p.String() != beforeStr {
+		t.Fatalf("TestPruneFailUnchanged fail. Expected\n%s\ngot\n%s",
+			beforeStr, p.String())
+	}
+	for _, hash := range hashes[:2] {
+		if !p.HasLeaf(hash) {
+			t.Fatalf("TestPruneFailUnchanged fail. Leaf %s was pruned",
+				hex.EncodeToString(hash[:]))
+		}
+	}
+	err = p.checkHashes()
+	if err != nil {
+		t.Fatalf("TestPruneFailUnchanged fail. Error: %v", err)
+	}
+}
+
+func TestModifyOutOfRange(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		numLeaves uint32
+		dels      []uint64
+	}{
+		{0, []uint64{0}},
+		{8, []uint64{0, 15}},
+		{8, []uint64{100}},
+		{8, []uint64{1<<64 - 1}},
+		// 6 is under the maximum position for 5 leaves but it doesn't exist.
+		{5, []uint64{2, 6}},
+		{5, []uint64{11}},
+	}
+
+	for _, test := range tests {
+		p := NewAccumulator(true)
+		leaves, _, _ := getAddsAndDels(0, test.numLeaves, 0)
+		err := p.Modify(leaves, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		beforeRoots := p.GetRoots()
+		beforeStr := p.String()
+
+		// Use hashes that are in the accumulator so that a failed check
+		// would show up as a modified accumulator.
+		delHashes := make([]Hash, len(test.dels))
+		for i := range delHashes {
+			if i < len(leaves) {
+				delHashes[i] = leaves[i].Hash
+			}
+		}
+
+		err = p.Modify(nil, delHashes, test.dels)
+		if !errors.Is(err, ErrProofMalformed) {
+			t.Fatalf("TestModifyOutOfRange fail. Expected %v for dels %v with "+
+				"%d leaves, got %v", ErrProofMalformed, test.dels, test.numLeaves, err)
+		}
+
+		if !reflect.DeepEqual(p.GetRoots(), beforeRoots) || p.String() != beforeStr {
+			t.Fatalf("TestModifyOutOfRange fail. Accumulator was modified on error")
+		}
+		err = p.posMapSanity()
+		if err != nil {
+			t.Fatalf("TestModifyOutOfRange fail. Error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package utreexo
+
+import "errors"
+
+// The errors below are wrapped by the errors returned from proving, verifying and
+// modifying so that the caller is able to tell the kind of failure apart with
+// errors.Is. The returned errors still include the details of the failure.
+var (
+	// ErrHashNotFound is returned when a hash isn't in the accumulator or when
+	// a hash needed for a proof isn't cached.
+	ErrHashNotFound = errors.New("hash not found")
+
+	// ErrRootMismatch is returned when the roots calculated from the proof
+	// don't match the roots of the accumulator.
+	ErrRootMismatch = errors.New("root mismatch")
+
+	// ErrProofMalformed is returned when the proof can't be used to calculate
+	// the roots. This happens if the proof is missing hashes, if the targets and
+	// the hashes don't line up, or if a target is an ancestor of another target.
+	ErrProofMalformed = errors.New("malformed proof")
+
+	// ErrTooManyLeaves is returned when numLeaves is more than MaxNumLeaves.
+	ErrTooManyLeaves = errors.New("too many leaves")
+)
@@ -1,6 +1,7 @@
 package utreexo
 
 import (
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
 	"sort"
@@ -28,6 +29,55 @@ type Leaf struct {
 	Remember bool
 }
 
+// LeafData is a leaf hash along with optional metadata that the leaf commits to.
+type LeafData struct {
+	Hash
+	Metadata []byte
+}
+
+// LeafHash returns the hash that's added to the accumulator for the leaf data. A
+// leaf without metadata is added as just its hash so plain 32 byte leaves are
+// unaffected. Otherwise it's the SHA512/256 hash of the hash and the metadata.
+func (l LeafData) LeafHash() Hash {
+	if len(l.Metadata) == 0 {
+		return l.Hash
+	}
+
+	h := sha512.New512_256()
+	h.Write(l.Hash[:])
+	h.Write(l.Metadata)
+	return *((*Hash)(h.Sum(nil)))
+}
+
+// Leaf returns the leaf to add to the accumulator for the leaf data.
+func (l LeafData) Leaf(remember bool) Leaf {
+	return Leaf{Hash: l.LeafHash(), Remember: remember}
+}
+
+// LeafMode is how the leaves of a pollard commit to their data.
+type LeafMode uint8
+
+const (
+	// LeafModeHash is the default mode where every leaf is just a 32 byte hash.
+	LeafModeHash LeafMode = iota
+
+	// LeafModeMetadata is the mode where the leaves are added as LeafData. The
+	// pollard keeps the metadata of the cached leaves so that the metadata is
+	// included in the proofs for those leaves.
+	LeafModeMetadata
+)
+
+// LeafDataProof is a proof for leaves that were added as LeafData. It carries the
+// hash and the metadata of every target so that the verifier learns the metadata
+// that the leaves commit to.
+type LeafDataProof struct {
+	// Leaves are the leaf data of the targets in the same order as the targets.
+	Leaves []LeafData
+
+	// Proof is the proof for the leaf hashes of the Leaves.
+	Proof Proof
+}
+
 // polNode is a node in the pollard.
 type polNode struct {
 	lNiece, rNiece *polNode
@@ -168,6 +218,18 @@ func (p *Pollard) getHash(pos uint64) Hash {
 	return n.data
 }
 
+// getHashes is getHash for multiple positions. The returned hashes are in the same
+// order as the positions and an empty hash is returned for the positions whose
+// hash couldn't be read.
+func (p *Pollard) getHashes(positions []uint64) []Hash {
+	hashes := make([]Hash, len(positions))
+	for i, pos := range positions {
+		hashes[i] = p.getHash(pos)
+	}
+
+	return hashes
+}
+
 func (p *Pollard) calculatePosition(node *polNode) uint64 {
 	// Tells whether to follow the left child or the right child when going
 	// down the tree. 0 means left, 1 means right.
@@ -411,14 +473,14 @@ func updateAunt(n *polNode) {
 
 // hashToRoot calculates the hash of the node passed in and all its ancestors
 // up to the root.
-func hashToRoot(node *polNode) error {
+func hashToRoot(node *polNode, hasher Hasher) error {
 	for node != nil {
 		// Grab children of this parent.
 		leftChild, rightChild, err := node.getChildren()
 		if err != nil {
 			return err
 		}
-		node.data = parentHash(leftChild.data, rightChild.data)
+		node.data = hasher.ParentHash(leftChild.data, rightChild.data)
 
 		// Grab the next parent that needs the hash updated.
 		node, err = node.getParent()
@@ -439,11 +501,11 @@ func getCount(n *polNode) int64 {
 }
 
 // calculateParentHash returns the parent hash of the passed in nodes.
-func calculateParentHash(nodePos uint64, node, sibling *polNode) Hash {
+func calculateParentHash(nodePos uint64, node, sibling *polNode, hasher Hasher) Hash {
 	if isLeftNiece(nodePos) {
-		return parentHash(node.data, sibling.data)
+		return hasher.ParentHash(node.data, sibling.data)
 	}
-	return parentHash(sibling.data, node.data)
+	return hasher.ParentHash(sibling.data, node.data)
 }
 
 type nodeAndPos struct {
@@ -451,7 +513,7 @@ type nodeAndPos struct {
 	pos  uint64
 }
 
-func deTwinPolNode(polNodes []nodeAndPos, forestRows uint8) []nodeAndPos {
+func deTwinPolNode(polNodes []nodeAndPos, forestRows uint8, hasher Hasher) []nodeAndPos {
 	for i := 0; i < len(polNodes); i++ {
 		// 1: Check that there's at least 2 elements in the slice left.
 		// 2: Check if the right sibling of the current element matches
@@ -470,7 +532,7 @@ func deTwinPolNode(polNodes []nodeAndPos, forestRows uint8) []nodeAndPos {
 			polNodes = append(polNodes[:i], polNodes[i+2:]...)
 
 			// Calculate and insert the parent in order.
-			parentNode := &polNode{data: parentHash(pn.node.data, sibNode.data)}
+			parentNode := &polNode{data: hasher.ParentHash(pn.node.data, sibNode.data)}
 			parentNode.lNiece = pn.node
 			parentNode.rNiece = sibNode
 			updateAunt(parentNode)
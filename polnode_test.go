@@ -174,3 +174,38 @@ func TestReadPosition(t *testing.T) {
 		}
 	}
 }
+
+func TestGetHashes(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 11, 0)
+	err := p.Modify(leaves, nil, []uint64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(nil, []Hash{leaves[4].Hash}, []uint64{4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Include positions that don't exist in the forest as well.
+	positions := make([]uint64, 0, maxPosition(treeRows(p.numLeaves))+2)
+	for pos := uint64(0); pos < maxPosition(treeRows(p.numLeaves))+2; pos++ {
+		positions = append(positions, pos)
+	}
+
+	hashes := p.getHashes(positions)
+	if len(hashes) != len(positions) {
+		t.Fatalf("TestGetHashes fail. Expected %d hashes, got %d",
+			len(positions), len(hashes))
+	}
+	for i, pos := range positions {
+		expected := p.getHash(pos)
+		if hashes[i] != expected {
+			t.Fatalf("TestGetHashes fail. Expected %s for position %d, got %s",
+				hex.EncodeToString(expected[:]), pos,
+				hex.EncodeToString(hashes[i][:]))
+		}
+	}
+}
@@ -1,9 +1,16 @@
 package utreexo
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"sort"
+	"sync"
 
 	"golang.org/x/exp/slices"
 )
@@ -47,6 +54,666 @@ func (p *Proof) String() string {
 	return s
 }
 
+// OrderMode is the order that the proof hashes in a proof are in.
+type OrderMode uint8
+
+const (
+	// OrderPosition orders the proof hashes row by row and by position within
+	// each row. This is the order used everywhere in this package.
+	OrderPosition OrderMode = iota
+
+	// OrderTargetSibling orders the proof hashes by going through the targets
+	// in ascending order and listing the siblings needed to hash each target up
+	// to its root. Siblings already listed for a previous target are skipped.
+	OrderTargetSibling
+)
+
+// ReorderProofHashes returns a copy of the proof with the proof hashes put in the
+// given order. The proof hashes are expected to be in the other order: a proof in
+// OrderPosition is converted to OrderTargetSibling and vice versa. The proof hashes
+// are returned in the same order if the proof doesn't match the numLeaves.
+func (p *Proof) ReorderProofHashes(numLeaves uint64, mode OrderMode) Proof {
+	reordered := Proof{
+		Targets: make([]uint64, len(p.Targets)),
+		Proof:   make([]Hash, len(p.Proof)),
+	}
+	copy(reordered.Targets, p.Targets)
+	copy(reordered.Proof, p.Proof)
+
+	forestRows := treeRows(numLeaves)
+	targets := sortedTargets(p.Targets)
+	positions, _ := proofPositions(targets, numLeaves, forestRows)
+	altPositions := targetSiblingOrder(targets, positions, numLeaves, forestRows)
+	if len(positions) != len(p.Proof) || len(altPositions) != len(p.Proof) {
+		return reordered
+	}
+
+	from, to := positions, altPositions
+	if mode == OrderPosition {
+		from, to = altPositions, positions
+	}
+
+	idx := make(map[uint64]int, len(from))
+	for i, pos := range from {
+		idx[pos] = i
+	}
+	for i, pos := range to {
+		reordered.Proof[i] = p.Proof[idx[pos]]
+	}
+
+	return reordered
+}
+
+// targetSiblingOrder returns the proof positions in OrderTargetSibling. The
+// targets must be sorted and proofPos must be the proof positions for them.
+func targetSiblingOrder(targets, proofPos []uint64, numLeaves uint64, forestRows uint8) []uint64 {
+	needed := make(map[uint64]struct{}, len(proofPos))
+	for _, pos := range proofPos {
+		needed[pos] = struct{}{}
+	}
+
+	ordered := make([]uint64, 0, len(proofPos))
+	for _, target := range targets {
+		for pos := target; !isRootPosition(pos, numLeaves, forestRows); pos = parent(pos, forestRows) {
+			sib := sibling(pos)
+			if _, found := needed[sib]; found {
+				ordered = append(ordered, sib)
+				delete(needed, sib)
+			}
+		}
+	}
+
+	return ordered
+}
+
+// Serialize encodes the proof to the writer and returns the count of bytes
+// written. The proof hashes are written in the order they're in. The encoding is:
+//
+// [4 bytes target count big-endian][8 bytes per target big-endian]
+// [4 bytes proof hash count big-endian][32 bytes per proof hash]
+func (p *Proof) Serialize(w io.Writer) (int, error) {
+	var buf [8]byte
+	totalBytes := 0
+
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(p.Targets)))
+	n, err := w.Write(buf[:4])
+	totalBytes += n
+	if err != nil {
+		return totalBytes, err
+	}
+
+	for _, target := range p.Targets {
+		binary.BigEndian.PutUint64(buf[:], target)
+		n, err = w.Write(buf[:])
+		totalBytes += n
+		if err != nil {
+			return totalBytes, err
+		}
+	}
+
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(p.Proof)))
+	n, err = w.Write(buf[:4])
+	totalBytes += n
+	if err != nil {
+		return totalBytes, err
+	}
+
+	for _, hash := range p.Proof {
+		n, err = w.Write(hash[:])
+		totalBytes += n
+		if err != nil {
+			return totalBytes, err
+		}
+	}
+
+	return totalBytes, nil
+}
+
+// SerializeWithOrder is Serialize but the proof hashes are written in the given
+// order. The proof hashes of the proof must be in OrderPosition.
+func (p *Proof) SerializeWithOrder(w io.Writer, numLeaves uint64, mode OrderMode) (int, error) {
+	if mode == OrderPosition {
+		return p.Serialize(w)
+	}
+
+	reordered := p.ReorderProofHashes(numLeaves, mode)
+	return reordered.Serialize(w)
+}
+
+// Deserialize decodes a proof serialized with Serialize from the reader. The
+// proof is only modified if the entire proof was read successfully.
+func (p *Proof) Deserialize(r io.Reader) error {
+	var buf [8]byte
+	_, err := io.ReadFull(r, buf[:4])
+	if err != nil {
+		return fmt.Errorf("Proof.Deserialize fail. Couldn't read target count. Error: %v", err)
+	}
+	targetCount := binary.BigEndian.Uint32(buf[:4])
+
+	// Append instead of allocating upfront so that a bogus count doesn't
+	// allocate more than what's actually read.
+	var targets []uint64
+	for i := uint32(0); i < targetCount; i++ {
+		_, err = io.ReadFull(r, buf[:])
+		if err != nil {
+			return fmt.Errorf("Proof.Deserialize fail. Couldn't read target %d. Error: %v",
+				i, err)
+		}
+		targets = append(targets, binary.BigEndian.Uint64(buf[:]))
+	}
+
+	_, err = io.ReadFull(r, buf[:4])
+	if err != nil {
+		return fmt.Errorf("Proof.Deserialize fail. Couldn't read proof hash count. Error: %v", err)
+	}
+	proofCount := binary.BigEndian.Uint32(buf[:4])
+
+	var proof []Hash
+	for i := uint32(0); i < proofCount; i++ {
+		var hash Hash
+		_, err = io.ReadFull(r, hash[:])
+		if err != nil {
+			return fmt.Errorf("Proof.Deserialize fail. Couldn't read proof hash %d. Error: %v",
+				i, err)
+		}
+		proof = append(proof, hash)
+	}
+
+	p.Targets = targets
+	p.Proof = proof
+
+	return nil
+}
+
+// DeserializeWithOrder is Deserialize for a proof that was serialized with the
+// proof hashes in the given order. The proof hashes are put back in OrderPosition.
+func (p *Proof) DeserializeWithOrder(r io.Reader, numLeaves uint64, mode OrderMode) error {
+	var read Proof
+	err := read.Deserialize(r)
+	if err != nil {
+		return err
+	}
+
+	if mode != OrderPosition {
+		read = read.ReorderProofHashes(numLeaves, OrderPosition)
+	}
+	*p = read
+
+	return nil
+}
+
+// ToHex returns the proof serialized with Serialize as a hex string.
+func (p *Proof) ToHex() string {
+	var buf bytes.Buffer
+	// Writing to a bytes.Buffer never returns an error.
+	p.Serialize(&buf)
+	return hex.EncodeToString(buf.Bytes())
+}
+
+// ProofFromHex decodes a proof from a hex string returned by ToHex. An error is
+// returned if the string isn't valid hex or if it isn't exactly one serialized proof.
+func ProofFromHex(s string) (Proof, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProofFromHex fail. Invalid hex. Error: %v", err)
+	}
+
+	r := bytes.NewReader(b)
+	var proof Proof
+	err = proof.Deserialize(r)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProofFromHex fail. Error: %v", err)
+	}
+	if r.Len() != 0 {
+		return Proof{}, fmt.Errorf("ProofFromHex fail. Have %d bytes left over "+
+			"after the proof", r.Len())
+	}
+
+	return proof, nil
+}
+
+// jsonProof is the JSON representation of a Proof.
+type jsonProof struct {
+	Targets []uint64 `json:"targets"`
+	Proof   []string `json:"proof"`
+}
+
+// MarshalJSON encodes the proof as a JSON object with the targets as an array of
+// numbers and the proof hashes as an array of hex strings.
+func (p Proof) MarshalJSON() ([]byte, error) {
+	jp := jsonProof{
+		Targets: p.Targets,
+		Proof:   make([]string, len(p.Proof)),
+	}
+	if jp.Targets == nil {
+		jp.Targets = []uint64{}
+	}
+	for i, hash := range p.Proof {
+		jp.Proof[i] = hex.EncodeToString(hash[:])
+	}
+
+	return json.Marshal(jp)
+}
+
+// UnmarshalJSON decodes a proof encoded with MarshalJSON. The proof is only
+// modified if the entire proof was decoded successfully.
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var jp jsonProof
+	err := json.Unmarshal(data, &jp)
+	if err != nil {
+		return fmt.Errorf("Proof.UnmarshalJSON fail. Error: %v", err)
+	}
+
+	var hashes []Hash
+	if len(jp.Proof) > 0 {
+		hashes = make([]Hash, len(jp.Proof))
+	}
+	for i, str := range jp.Proof {
+		if len(str) != len(Hash{})*2 {
+			return fmt.Errorf("Proof.UnmarshalJSON fail. Proof hash %d is %d "+
+				"characters long but should be %d", i, len(str), len(Hash{})*2)
+		}
+		_, err = hex.Decode(hashes[i][:], []byte(str))
+		if err != nil {
+			return fmt.Errorf("Proof.UnmarshalJSON fail. Couldn't decode proof "+
+				"hash %d. Error: %v", i, err)
+		}
+	}
+
+	var targets []uint64
+	if len(jp.Targets) > 0 {
+		targets = jp.Targets
+	}
+	p.Targets = targets
+	p.Proof = hashes
+
+	return nil
+}
+
+// Equal returns true if the two proofs are for the same targets and have the
+// same proof hashes. The order of the targets doesn't matter.
+func (p *Proof) Equal(other *Proof) bool {
+	if len(p.Targets) != len(other.Targets) || len(p.Proof) != len(other.Proof) {
+		return false
+	}
+
+	return slices.Equal(sortedTargets(p.Targets), sortedTargets(other.Targets)) &&
+		slices.Equal(p.Proof, other.Proof)
+}
+
+// CacheKey returns a hash committing to the sorted targets and the proof hashes.
+// Proofs that are Equal will return the same key.
+func (p *Proof) CacheKey() Hash {
+	h := sha512.New512_256()
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(len(p.Targets)))
+	h.Write(buf[:])
+	for _, target := range sortedTargets(p.Targets) {
+		binary.BigEndian.PutUint64(buf[:], target)
+		h.Write(buf[:])
+	}
+
+	binary.BigEndian.PutUint64(buf[:], uint64(len(p.Proof)))
+	h.Write(buf[:])
+	for _, hash := range p.Proof {
+		h.Write(hash[:])
+	}
+
+	return *((*Hash)(h.Sum(nil)))
+}
+
+// SortWith sorts the targets of the proof and returns the delHashes reordered so
+// that they're paired with the same targets as before. The delHashes passed in
+// are not modified. Nil is returned and the proof isn't modified if the number
+// of delHashes and targets differ.
+func (p *Proof) SortWith(delHashes []Hash) []Hash {
+	if len(delHashes) != len(p.Targets) {
+		return nil
+	}
+
+	hnp := toHashAndPos(p.Targets, delHashes)
+
+	sorted := make([]Hash, len(hnp))
+	for i := range hnp {
+		p.Targets[i] = hnp[i].pos
+		sorted[i] = hnp[i].hash
+	}
+
+	return sorted
+}
+
+// Dedup returns a copy of the proof with the duplicate targets removed along with
+// the delHashes that are still paired with the targets. The first occurrence of
+// each target is kept so the order of the targets stays the same. The proof hashes
+// are left as is since Prove calculates them from the deduplicated targets.
+//
+// An error is returned if the same target is paired with different hashes or if
+// the count of the delHashes and the targets differ.
+func (p *Proof) Dedup(delHashes []Hash) (Proof, []Hash, error) {
+	if len(delHashes) != len(p.Targets) {
+		return Proof{}, nil, fmt.Errorf("Proof.Dedup fail. %w. Was given %d "+
+			"targets but got %d hashes", ErrProofMalformed, len(p.Targets), len(delHashes))
+	}
+
+	seen := make(map[uint64]Hash, len(p.Targets))
+	deduped := Proof{
+		Targets: make([]uint64, 0, len(p.Targets)),
+		Proof:   make([]Hash, len(p.Proof)),
+	}
+	copy(deduped.Proof, p.Proof)
+	hashes := make([]Hash, 0, len(delHashes))
+	for i, target := range p.Targets {
+		hash, found := seen[target]
+		if found {
+			if hash != delHashes[i] {
+				return Proof{}, nil, fmt.Errorf("Proof.Dedup fail. %w. Target %d "+
+					"is paired with both %s and %s", ErrProofMalformed, target,
+					hex.EncodeToString(hash[:]), hex.EncodeToString(delHashes[i][:]))
+			}
+			continue
+		}
+		seen[target] = delHashes[i]
+
+		deduped.Targets = append(deduped.Targets, target)
+		hashes = append(hashes, delHashes[i])
+	}
+
+	return deduped, hashes, nil
+}
+
+// Canonicalize returns a copy of the proof with the targets sorted in ascending
+// order. The proof hashes are already in the canonical proofPositions order as
+// their order only depends on the set of targets and not on the order of the
+// targets. The serialized bytes of canonical proofs for the same targets are
+// equal.
+//
+// NOTE The proof hashes must be in the position order as returned by Prove.
+// Proofs reordered with ReorderProofHashes should be put back in the position
+// order before being canonicalized. The delHashes of the proof also need to be
+// reordered to stay paired with the targets. Use SortWith for that.
+func (p *Proof) Canonicalize() Proof {
+	canonical := Proof{
+		Targets: sortedTargets(p.Targets),
+		Proof:   make([]Hash, len(p.Proof)),
+	}
+	copy(canonical.Proof, p.Proof)
+
+	return canonical
+}
+
+// SanityCheck checks that the proof is well formed for an accumulator with the
+// given numLeaves without needing the roots. It checks that all the targets exist
+// in the forest, that there are no duplicate targets, and that the number of proof
+// hashes is the same as the number of positions needed to prove the targets. An
+// error wrapping ErrProofMalformed that describes the first problem found is
+// returned.
+func (p *Proof) SanityCheck(numLeaves uint64) error {
+	if numLeaves == 0 && len(p.Targets) > 0 {
+		return fmt.Errorf("Proof.SanityCheck fail. %w. Have %d targets but "+
+			"the accumulator is empty", ErrProofMalformed, len(p.Targets))
+	}
+
+	forestRows := treeRows(numLeaves)
+	seen := make(map[uint64]int, len(p.Targets))
+	for i, target := range p.Targets {
+		if !inForest(target, numLeaves, forestRows) {
+			return fmt.Errorf("Proof.SanityCheck fail. %w. Target %d at index %d "+
+				"is out of range for %d leaves", ErrProofMalformed, target, i, numLeaves)
+		}
+
+		if idx, found := seen[target]; found {
+			return fmt.Errorf("Proof.SanityCheck fail. %w. Target %d is duplicated "+
+				"at index %d and %d", ErrProofMalformed, target, idx, i)
+		}
+		seen[target] = i
+	}
+
+	positions, _ := proofPositions(sortedTargets(p.Targets), numLeaves, forestRows)
+	if len(positions) != len(p.Proof) {
+		return fmt.Errorf("Proof.SanityCheck fail. %w. Expected %d proof hashes "+
+			"for the targets but have %d", ErrProofMalformed, len(positions), len(p.Proof))
+	}
+
+	return nil
+}
+
+// CompressionSavings returns how many fewer proof hashes the proof needs compared
+// to proving each of its targets on its own. Targets that share a path to a root
+// share proof hashes and targets that are able to be calculated from other
+// targets don't need a proof hash at all.
+func (p *Proof) CompressionSavings(numLeaves uint64) int {
+	forestRows := treeRows(numLeaves)
+
+	fullCount := 0
+	for _, target := range p.Targets {
+		for pos := target; !isRootPosition(pos, numLeaves, forestRows); pos = parent(pos, forestRows) {
+			fullCount++
+		}
+	}
+
+	positions, _ := proofPositions(sortedTargets(p.Targets), numLeaves, forestRows)
+	return fullCount - len(positions)
+}
+
+// sortedTargets returns a sorted copy of the targets.
+func sortedTargets(targets []uint64) []uint64 {
+	sorted := make([]uint64, len(targets))
+	copy(sorted, targets)
+	slices.Sort(sorted)
+	return sorted
+}
+
+// ProveSingle returns a proof for a single hash. It's the same as calling Prove
+// with just the one hash but skips the sorting and the proof position calculation
+// needed for multiple targets.
+func (p *Pollard) ProveSingle(hash Hash) (Proof, error) {
+	if p.numLeaves == 0 {
+		return Proof{}, nil
+	}
+
+	node, ok := p.nodeMap[hash.mini()]
+	if !ok {
+		return Proof{}, fmt.Errorf("ProveSingle error: %w: %s",
+			ErrHashNotFound, hex.EncodeToString(hash[:]))
+	}
+	target := p.calculatePosition(node)
+
+	// A Pollard with 1 leaf has no proof and only 1 target.
+	if p.numLeaves == 1 {
+		return Proof{Targets: []uint64{target}}, nil
+	}
+
+	// The proof is the sibling of every node from the target up to the root.
+	forestRows := treeRows(p.numLeaves)
+	proof := Proof{
+		Targets: []uint64{target},
+		Proof:   make([]Hash, 0, forestRows),
+	}
+	for pos := target; !isRootPosition(pos, p.numLeaves, forestRows); pos = parent(pos, forestRows) {
+		proofPos := sibling(pos)
+		hash := p.getHash(proofPos)
+		if hash == empty {
+			return Proof{}, fmt.Errorf("ProveSingle error: %w. Couldn't read "+
+				"position %d", ErrHashNotFound, proofPos)
+		}
+		proof.Proof = append(proof.Proof, hash)
+	}
+
+	return proof, nil
+}
+
+// ProveStable returns a proof for a single hash that leaves out the proof hashes
+// at or above stableRow. The hashes high up in the forest change less often than
+// the lower ones so a client watching the leaf is able to fetch the stable hashes
+// once with StableProofHashes and only refresh the proof returned by ProveStable
+// as the forest changes. AttachStableHashes puts the two back together into a
+// proof that is able to be verified.
+//
+// An error is returned if stableRow is above the row of the root of the leaf.
+func (p *Pollard) ProveStable(h Hash, stableRow uint8) (Proof, error) {
+	proof, split, err := p.proveStableSplit(h, stableRow)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProveStable error: %w", err)
+	}
+	proof.Proof = proof.Proof[:split]
+
+	return proof, nil
+}
+
+// StableProofHashes returns the proof hashes for the hash at or above stableRow,
+// ordered from the lowest row to the highest. These are the hashes that
+// ProveStable leaves out.
+//
+// An error is returned if stableRow is above the row of the root of the leaf.
+func (p *Pollard) StableProofHashes(h Hash, stableRow uint8) ([]Hash, error) {
+	proof, split, err := p.proveStableSplit(h, stableRow)
+	if err != nil {
+		return nil, fmt.Errorf("StableProofHashes error: %w", err)
+	}
+
+	return proof.Proof[split:], nil
+}
+
+// proveStableSplit returns a proof for the hash along with the index of the first
+// proof hash that's at or above stableRow.
+func (p *Pollard) proveStableSplit(h Hash, stableRow uint8) (Proof, int, error) {
+	proof, err := p.ProveSingle(h)
+	if err != nil {
+		return Proof{}, 0, err
+	}
+
+	// The proof hashes of a single target are on consecutive rows starting
+	// from the row of the target.
+	forestRows := treeRows(p.numLeaves)
+	targetRow := detectRow(proof.Targets[0], forestRows)
+	rootRow := int(targetRow) + len(proof.Proof)
+	if int(stableRow) > rootRow {
+		return Proof{}, 0, fmt.Errorf("stableRow %d is above the root at row %d",
+			stableRow, rootRow)
+	}
+
+	split := 0
+	if stableRow > targetRow {
+		split = int(stableRow - targetRow)
+	}
+
+	return proof, split, nil
+}
+
+// AttachStableHashes returns the proof from ProveStable with the hashes from
+// StableProofHashes added back so that it's able to be verified.
+func AttachStableHashes(proof Proof, stable []Hash) Proof {
+	hashes := make([]Hash, 0, len(proof.Proof)+len(stable))
+	hashes = append(hashes, proof.Proof...)
+	hashes = append(hashes, stable...)
+
+	targets := make([]uint64, len(proof.Targets))
+	copy(targets, proof.Targets)
+
+	return Proof{Targets: targets, Proof: hashes}
+}
+
+// ProveWithPaths is Prove but also returns the path from each target to its root.
+// paths[i] starts with proof.Targets[i] and ends with the position of its root.
+func (p *Pollard) ProveWithPaths(hashes []Hash) (Proof, [][]uint64, error) {
+	proof, err := p.Prove(hashes)
+	if err != nil {
+		return Proof{}, nil, err
+	}
+
+	forestRows := treeRows(p.numLeaves)
+	paths := make([][]uint64, len(proof.Targets))
+	for i, target := range proof.Targets {
+		paths[i] = pathToRoot(target, p.numLeaves, forestRows)
+	}
+
+	return proof, paths, nil
+}
+
+// pathToRoot returns the positions from the given position up to its root.
+func pathToRoot(pos, numLeaves uint64, forestRows uint8) []uint64 {
+	path := []uint64{pos}
+	for !isRootPosition(pos, numLeaves, forestRows) {
+		pos = parent(pos, forestRows)
+		path = append(path, pos)
+	}
+
+	return path
+}
+
+// ProveLeafData returns a proof for the leaves with the given leaf hashes along with
+// the metadata the leaves commit to. The leaf hashes are the hashes returned by
+// LeafData.LeafHash for the leaves added with AddLeafData. An error is returned if
+// the pollard isn't in LeafModeMetadata.
+func (p *Pollard) ProveLeafData(leafHashes []Hash) (LeafDataProof, error) {
+	if p.leafMode != LeafModeMetadata {
+		return LeafDataProof{}, fmt.Errorf("ProveLeafData fail. Pollard isn't in " +
+			"LeafModeMetadata")
+	}
+
+	leaves := make([]LeafData, len(leafHashes))
+	for i, hash := range leafHashes {
+		leaf, found := p.leafData[hash.mini()]
+		if !found || leaf.LeafHash() != hash {
+			return LeafDataProof{}, fmt.Errorf("ProveLeafData fail. %w: %s",
+				ErrHashNotFound, hex.EncodeToString(hash[:]))
+		}
+		leaves[i] = LeafData{Hash: leaf.Hash, Metadata: append([]byte(nil), leaf.Metadata...)}
+	}
+
+	proof, err := p.Prove(leafHashes)
+	if err != nil {
+		return LeafDataProof{}, fmt.Errorf("ProveLeafData fail. Error: %w", err)
+	}
+
+	return LeafDataProof{Leaves: leaves, Proof: proof}, nil
+}
+
+// VerifyLeafData verifies the proof against the roots of the pollard. Since the leaf
+// hashes commit to the metadata, the proof only verifies if the metadata in the
+// proof is the metadata that was added. An error is returned if the pollard isn't
+// in LeafModeMetadata.
+func (p *Pollard) VerifyLeafData(proof LeafDataProof) error {
+	if p.leafMode != LeafModeMetadata {
+		return fmt.Errorf("VerifyLeafData fail. Pollard isn't in LeafModeMetadata")
+	}
+
+	err := p.Verify(leafHashes(proof.Leaves), proof.Proof)
+	if err != nil {
+		return fmt.Errorf("VerifyLeafData fail. Error: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyLeafDataProof verifies the proof against the stump. On success, the leaf data
+// in the proof is the data that the leaves in the accumulator commit to.
+func VerifyLeafDataProof(stump Stump, proof LeafDataProof) error {
+	_, err := StumpVerify(stump, leafHashes(proof.Leaves), proof.Proof)
+	if err != nil {
+		return fmt.Errorf("VerifyLeafDataProof fail. Error: %w", err)
+	}
+
+	return nil
+}
+
+// leafHashes returns the leaf hashes of the leaf data.
+func leafHashes(leaves []LeafData) []Hash {
+	hashes := make([]Hash, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = leaf.LeafHash()
+	}
+
+	return hashes
+}
+
+// Prove returns a proof of all the hashes passed in. The targets of the proof are
+// in the same order as the hashes so the targets are able to be related back to
+// the order the hashes were passed in. The proof hashes are ordered by position.
+//
+// Duplicate hashes are kept as duplicate targets. Use Proof.Dedup to remove them
+// before verifying the proof.
 func (p *Pollard) Prove(hashes []Hash) (Proof, error) {
 	// No hashes to prove means that the proof is empty. An empty
 	// pollard also has an empty proof.
@@ -58,43 +725,354 @@ func (p *Pollard) Prove(hashes []Hash) (Proof, error) {
 		return Proof{Targets: []uint64{0}}, nil
 	}
 
-	var proof Proof
-	proof.Targets = make([]uint64, len(hashes))
-
 	// Grab the positions of the hashes that are to be proven.
-	for i, wanted := range hashes {
-		node, ok := p.nodeMap[wanted.mini()]
-		if !ok {
-			return proof, fmt.Errorf("Prove error: hash %s not found",
-				hex.EncodeToString(wanted[:]))
+	targets, err := p.hashesToPositions(hashes)
+	if err != nil {
+		return Proof{}, fmt.Errorf("Prove error: %w", err)
+	}
+	if p.proofCache != nil {
+		p.proofCache.sync(p.ToStump())
+		if cached, found := p.proofCache.Get(targets); found {
+			return cached, nil
 		}
-		proof.Targets[i] = p.calculatePosition(node)
 	}
+	proof := Proof{Targets: targets}
 
-	// Sort the targets as the proof hashes need to be sorted.
+	// Sort the targets as the proof hashes need to be sorted. Only a copy is
+	// sorted so proof.Targets stays in the same order as the hashes passed in
+	// and the in-block position information isn't lost.
 	//
-	// TODO find out if sorting and losing in-block position information hurts
-	// locality or performance.
+	// TODO find out if sorting hurts locality or performance.
 	sortedTargets := make([]uint64, len(proof.Targets))
 	copy(sortedTargets, proof.Targets)
 	sort.Slice(sortedTargets, func(a, b int) bool { return sortedTargets[a] < sortedTargets[b] })
 
+	// Duplicate hashes give duplicate targets. Remove them so that the proof
+	// hashes are the same as the proof hashes for the deduplicated targets.
+	sortedTargets = slices.Compact(sortedTargets)
+
 	// Get the positions of all the hashes that are needed to prove the targets
 	proofPositions, _ := proofPositions(sortedTargets, p.numLeaves, treeRows(p.numLeaves))
 
 	// Fetch all the proofs from the accumulator.
-	proof.Proof = make([]Hash, len(proofPositions))
-	for i, proofPos := range proofPositions {
-		hash := p.getHash(proofPos)
+	proof.Proof = p.getHashes(proofPositions)
+	for i, hash := range proof.Proof {
 		if hash == empty {
-			return Proof{}, fmt.Errorf("Prove error: couldn't read position %d", proofPos)
+			return Proof{}, fmt.Errorf("Prove error: %w. Couldn't read "+
+				"position %d", ErrHashNotFound, proofPositions[i])
+		}
+	}
+	if p.proofCache != nil {
+		p.proofCache.Put(proof.Targets, proof)
+	}
+
+	return proof, nil
+}
+
+// SetProofCache makes Prove look up and store proofs in the given cache. Setting
+// the cache to nil stops Prove from caching proofs.
+func (p *Pollard) SetProofCache(c *ProofCache) {
+	p.proofCache = c
+}
+
+// ProofCache caches proofs by the set of targets being proven. The order of the
+// targets and duplicate targets don't matter when looking up a proof.
+//
+// A ProofCache used by a Pollard through SetProofCache is cleared by Prove whenever
+// the roots or the numLeaves of the pollard change. A ProofCache used on its own
+// must be cleared by the caller.
+//
+// ProofCache is safe for concurrent use.
+type ProofCache struct {
+	mtx    sync.Mutex
+	stump  Stump
+	proofs map[string][]Hash
+}
+
+// NewProofCache returns an empty ProofCache.
+func NewProofCache() *ProofCache {
+	return &ProofCache{proofs: make(map[string][]Hash)}
+}
+
+// proofCacheKey returns the key for the set of targets.
+func proofCacheKey(targets []uint64) string {
+	sorted := slices.Compact(sortedTargets(targets))
+	key := make([]byte, len(sorted)*8)
+	for i, target := range sorted {
+		binary.BigEndian.PutUint64(key[i*8:], target)
+	}
+
+	return string(key)
+}
+
+// Get returns the cached proof for the targets. The targets of the returned proof
+// are the targets passed in.
+func (c *ProofCache) Get(targets []uint64) (Proof, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	hashes, found := c.proofs[proofCacheKey(targets)]
+	if !found {
+		return Proof{}, false
+	}
+
+	proof := Proof{
+		Targets: make([]uint64, len(targets)),
+		Proof:   make([]Hash, len(hashes)),
+	}
+	copy(proof.Targets, targets)
+	copy(proof.Proof, hashes)
+
+	return proof, true
+}
+
+// Put caches the proof for the targets. The proof hashes don't depend on the order
+// of the targets so the proof is returned by Get for any ordering of the targets.
+func (c *ProofCache) Put(targets []uint64, p Proof) {
+	hashes := make([]Hash, len(p.Proof))
+	copy(hashes, p.Proof)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.proofs[proofCacheKey(targets)] = hashes
+}
+
+// Clear removes all the cached proofs.
+func (c *ProofCache) Clear() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.proofs = make(map[string][]Hash)
+}
+
+// sync clears the cache if the stump is different from the stump that the cached
+// proofs were made for.
+func (c *ProofCache) sync(stump Stump) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.stump.NumLeaves == stump.NumLeaves && slices.Equal(c.stump.Roots, stump.Roots) {
+		return
+	}
+	c.stump = stump
+	c.proofs = make(map[string][]Hash)
+}
+
+// ProveTransition returns the proof needed to delete the leaves at the targets and
+// then add the addHashes in a single modification. Additions are able to be applied
+// with just the roots so the proof only proves the targets. The hashes at the
+// targets are needed along with the proof to verify and apply the modification.
+//
+// An error is returned if any of the targets aren't cached in the pollard or if
+// any of the addHashes are already in the accumulator.
+func (p *Pollard) ProveTransition(targets []uint64, addHashes []Hash) (Proof, error) {
+	for _, add := range addHashes {
+		if _, found := p.nodeMap[add.mini()]; found {
+			return Proof{}, fmt.Errorf("ProveTransition error: add %s is already "+
+				"in the accumulator", hex.EncodeToString(add[:]))
+		}
+	}
+
+	delHashes := make([]Hash, len(targets))
+	for i, target := range targets {
+		delHashes[i] = p.getHash(target)
+		if delHashes[i] == empty {
+			return Proof{}, fmt.Errorf("ProveTransition error: %w: couldn't "+
+				"read target %d", ErrHashNotFound, target)
+		}
+	}
+
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProveTransition error: %w", err)
+	}
+
+	return proof, nil
+}
+
+// ProveWithFetcher is Prove but the proof hashes that aren't cached in the pollard
+// are fetched with the passed in fetch function. The proof is verified before it's
+// returned as the fetched hashes may be coming from an untrusted source. The fetched
+// hashes are cached in the pollard if their siblings are already present.
+func (p *Pollard) ProveWithFetcher(hashes []Hash,
+	fetch func(positions []uint64) ([]Hash, error)) (Proof, error) {
+
+	// No hashes to prove means that the proof is empty. An empty
+	// pollard also has an empty proof.
+	if len(hashes) == 0 || p.numLeaves == 0 {
+		return Proof{}, nil
+	}
+	// A Pollard with 1 leaf has no proof and only 1 target.
+	if p.numLeaves == 1 {
+		return Proof{Targets: []uint64{0}}, nil
+	}
+
+	targets, err := p.hashesToPositions(hashes)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProveWithFetcher error: %w", err)
+	}
+	proof := Proof{Targets: targets}
+
+	sortedTargets := make([]uint64, len(proof.Targets))
+	copy(sortedTargets, proof.Targets)
+	sort.Slice(sortedTargets, func(a, b int) bool { return sortedTargets[a] < sortedTargets[b] })
+
+	// Duplicate hashes give duplicate targets. Remove them the same way Prove
+	// does so that the proof hashes match the ones from Prove.
+	sortedTargets = slices.Compact(sortedTargets)
+
+	proofPositions, _ := proofPositions(sortedTargets, p.numLeaves, treeRows(p.numLeaves))
+
+	// Fetch all the proofs we have from the accumulator and keep track of
+	// the ones that are missing.
+	var missing []uint64
+	var missingIdx []int
+	proof.Proof = p.getHashes(proofPositions)
+	for i, hash := range proof.Proof {
+		if hash == empty {
+			missing = append(missing, proofPositions[i])
+			missingIdx = append(missingIdx, i)
+		}
+	}
+
+	if len(missing) == 0 {
+		return proof, nil
+	}
+
+	fetched, err := fetch(missing)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProveWithFetcher error: couldn't fetch "+
+			"positions %v. Error: %v", missing, err)
+	}
+	if len(fetched) != len(missing) {
+		return Proof{}, fmt.Errorf("ProveWithFetcher error: requested %d "+
+			"positions but fetched %d hashes", len(missing), len(fetched))
+	}
+	for i, idx := range missingIdx {
+		proof.Proof[idx] = fetched[i]
+	}
+
+	err = p.Verify(hashes, proof)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProveWithFetcher error: invalid fetched "+
+			"hashes. Error: %v", err)
+	}
+
+	for i, pos := range missing {
+		err = p.ingest(pos, fetched[i])
+		if err != nil {
+			return Proof{}, err
 		}
-		proof.Proof[i] = hash
 	}
 
 	return proof, nil
 }
 
+// ingest caches the hash at the given position if the sibling of the position
+// is present in the pollard. Nothing is done if the sibling isn't present.
+func (p *Pollard) ingest(pos uint64, hash Hash) error {
+	sib, node, _, err := p.getNode(sibling(pos))
+	if err != nil {
+		return err
+	}
+	if sib == nil || node != nil || sib.aunt == nil {
+		return nil
+	}
+
+	// The nodes point to their nieces so the aunt of the sibling is the node
+	// that should be pointing to the ingested node.
+	aunt := sib.aunt
+
+	node = &polNode{data: hash, aunt: aunt}
+	if isLeftNiece(pos) {
+		aunt.lNiece = node
+	} else {
+		aunt.rNiece = node
+	}
+
+	return nil
+}
+
+// Ingest verifies the proof and caches the targets, the proof hashes, and all the
+// nodes calculated from them in the pollard. The targets are remembered and are
+// able to be proven afterwards without the pollard needing to fetch anything.
+// This lets a pollard that only keeps the roots build up a cache as it verifies
+// proofs.
+func (p *Pollard) Ingest(delHashes []Hash, proof Proof) error {
+	if len(delHashes) == 0 {
+		return nil
+	}
+
+	err := p.Verify(delHashes, proof)
+	if err != nil {
+		return fmt.Errorf("Pollard.Ingest fail. Error: %w", err)
+	}
+
+	known, err := knownPositions(p.hasher, p.numLeaves, proof, delHashes)
+	if err != nil {
+		return fmt.Errorf("Pollard.Ingest fail. %w. Error: %v", ErrProofMalformed, err)
+	}
+
+	// Go from the top down so that the node holding the nieces is always
+	// present before the nieces are attached to it. Positions on a higher row
+	// are always greater than the positions on a lower row.
+	positions := make([]uint64, 0, len(known))
+	for pos := range known {
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(a, b int) bool { return positions[a] > positions[b] })
+
+	forestRows := treeRows(p.numLeaves)
+	for _, pos := range positions {
+		if isRootPosition(pos, p.numLeaves, forestRows) {
+			continue
+		}
+
+		n, _, _, err := p.getNode(pos)
+		if err != nil {
+			return fmt.Errorf("Pollard.Ingest fail. Error: %v", err)
+		}
+		if n != nil {
+			continue
+		}
+
+		// Roots point to their children while the other nodes point to
+		// their nieces. The node at the parent position holds the node if
+		// the parent is a root. Otherwise it's the sibling of the parent.
+		auntPos := parent(pos, forestRows)
+		if !isRootPosition(auntPos, p.numLeaves, forestRows) {
+			auntPos = sibling(auntPos)
+		}
+		aunt, _, _, err := p.getNode(auntPos)
+		if err != nil {
+			return fmt.Errorf("Pollard.Ingest fail. Error: %v", err)
+		}
+		if aunt == nil {
+			return fmt.Errorf("Pollard.Ingest fail. Couldn't fetch the aunt "+
+				"at position %d for position %d", auntPos, pos)
+		}
+
+		n = &polNode{data: known[pos], aunt: aunt}
+		if isLeftNiece(pos) {
+			aunt.lNiece = n
+		} else {
+			aunt.rNiece = n
+		}
+	}
+
+	for i, target := range proof.Targets {
+		n, _, _, err := p.getNode(target)
+		if err != nil {
+			return fmt.Errorf("Pollard.Ingest fail. Error: %v", err)
+		}
+		n.remember = true
+		p.nodeMap[delHashes[i].mini()] = n
+	}
+
+	return nil
+}
+
 type hashAndPos struct {
 	hash Hash
 	pos  uint64
@@ -108,128 +1086,330 @@ func hashAndPosCmp(a, b hashAndPos) int {
 	} else if a.pos > b.pos {
 		return 1
 	}
-	return 0
-}
+	return 0
+}
+
+// toHashAndPos returns a slice of hash and pos that's sorted.
+func toHashAndPos(targets []uint64, hashes []Hash) []hashAndPos {
+	hnp := make([]hashAndPos, len(hashes))
+
+	for i := range hnp {
+		hnp[i].hash = hashes[i]
+		hnp[i].pos = targets[i]
+	}
+
+	// No guarantee that the targets and the delHashes are in order. Sort them
+	// before processing.
+	sort.Slice(hnp, func(a, b int) bool { return hnp[a].pos < hnp[b].pos })
+
+	return hnp
+}
+
+// Verify calculates the root hashes from the passed in proof and delHashes and
+// compares it against the current roots in the pollard.
+//
+// delHashes[i] must be the hash of the leaf at proof.Targets[i]. The targets
+// don't have to be sorted but if the delHashes are reordered, the targets must
+// be reordered the same way. Proof.SortWith can be used to sort both of them.
+func (p *Pollard) Verify(delHashes []Hash, proof Proof) error {
+	return p.verify(p.hasher, delHashes, proof, nil)
+}
+
+// VerifyWithCost is Verify but also returns how many parent hashes were calculated
+// during the verification. The count is returned even if the verification fails.
+func (p *Pollard) VerifyWithCost(delHashes []Hash, proof Proof) (int, error) {
+	counter := countingHasher{hasher: p.hasher}
+	err := p.verify(&counter, delHashes, proof, nil)
+	return counter.count, err
+}
+
+// countingHasher is a Hasher that counts how many times ParentHash was called.
+type countingHasher struct {
+	hasher Hasher
+	count  int
+}
+
+// ParentHash returns the parent hash from the underlying hasher and increments
+// the count.
+func (c *countingHasher) ParentHash(left, right Hash) Hash {
+	c.count++
+	return c.hasher.ParentHash(left, right)
+}
+
+// verify is Verify with the hasher used to calculate the roots passed in. The
+// buffers in scratch are used for the hashing if scratch isn't nil.
+func (p *Pollard) verify(hasher Hasher, delHashes []Hash, proof Proof, scratch *VerifyScratch) error {
+	if len(delHashes) == 0 {
+		return nil
+	}
+
+	err := checkProof(p.numLeaves, treeRows(p.numLeaves), delHashes, proof)
+	if err != nil {
+		return fmt.Errorf("Pollard.Verify fail. Error: %w", err)
+	}
+
+	rootCandidates, _, err := calculateHashes(context.Background(), hasher, p.numLeaves,
+		treeRows(p.numLeaves), delHashes, proof, false, scratch)
+	if err != nil {
+		return fmt.Errorf("Pollard.Verify fail. Error: %w", err)
+	}
+	if len(rootCandidates) == 0 {
+		return fmt.Errorf("Pollard.Verify fail. %w. No roots calculated "+
+			"but have %d deletions", ErrRootMismatch, len(delHashes))
+	}
+
+	// Error out if all the rootCandidates do not have a corresponding
+	// polnode with the same hash.
+	rootHashes := p.GetRoots()
+	err = matchRoots(rootHashes, rootCandidates)
+	if err != nil {
+		// Give a more descriptive error if the hashes weren't paired
+		// up with the right targets.
+		pairErr := p.checkPairing(delHashes, proof.Targets)
+		if pairErr != nil {
+			return fmt.Errorf("Pollard.Verify fail. Error: %w", pairErr)
+		}
+
+		return fmt.Errorf("Pollard.Verify fail. Error: %w.\nRootcandidates:\n%v\nRoots:\n%v",
+			err, printHashes(rootCandidates), printHashes(rootHashes))
+	}
+
+	return nil
+}
+
+// checkPairing returns an error if any of the delHashes that the pollard knows
+// the position of is paired with a different target.
+func (p *Pollard) checkPairing(delHashes []Hash, targets []uint64) error {
+	for i, delHash := range delHashes {
+		node, found := p.nodeMap[delHash.mini()]
+		if !found {
+			continue
+		}
+
+		pos := p.calculatePosition(node)
+		if pos != targets[i] {
+			return fmt.Errorf("%w. delHash %s at index %d is paired with target %d "+
+				"but is at position %d. The delHashes and the targets must be "+
+				"in the same order", ErrRootMismatch, hex.EncodeToString(delHash[:]),
+				i, targets[i], pos)
+		}
+	}
 
-// toHashAndPos returns a slice of hash and pos that's sorted.
-func toHashAndPos(targets []uint64, hashes []Hash) []hashAndPos {
-	hnp := make([]hashAndPos, len(hashes))
+	return nil
+}
 
-	for i := range hnp {
-		hnp[i].hash = hashes[i]
-		hnp[i].pos = targets[i]
+// VerifyWithRows verifies the proof against the roots for a forest that has
+// totalRows instead of treeRows(numLeaves). The targets in the proof must be in
+// the position space of totalRows. The roots must be ordered from the highest
+// root to the lowest root, same as GetRoots.
+func VerifyWithRows(numLeaves uint64, totalRows uint8, roots []Hash, delHashes []Hash, proof Proof) error {
+	if totalRows < treeRows(numLeaves) {
+		return fmt.Errorf("VerifyWithRows fail. totalRows of %d is less than %d "+
+			"which is needed for %d leaves", totalRows, treeRows(numLeaves), numLeaves)
 	}
 
-	// No guarantee that the targets and the delHashes are in order. Sort them
-	// before processing.
-	sort.Slice(hnp, func(a, b int) bool { return hnp[a].pos < hnp[b].pos })
+	_, _, err := verifyProof(context.Background(), DefaultHasher{}, roots, numLeaves,
+		totalRows, delHashes, proof, false, nil)
+	if err != nil {
+		return fmt.Errorf("VerifyWithRows fail. Error: %w", err)
+	}
 
-	return hnp
+	return nil
 }
 
-// Verify calculates the root hashes from the passed in proof and delHashes and
-// compares it against the current roots in the pollard.
-func (p *Pollard) Verify(delHashes []Hash, proof Proof) error {
-	if len(delHashes) == 0 {
-		return nil
+// checkTargetAncestors returns an error if any of the targets is an ancestor of
+// another target. A valid proof can't have both a node and its ancestor as targets.
+func checkTargetAncestors(targets []uint64, forestRows uint8) error {
+	targetSet := make(map[uint64]struct{}, len(targets))
+	for _, target := range targets {
+		targetSet[target] = struct{}{}
 	}
 
-	if len(delHashes) != len(proof.Targets) {
-		return fmt.Errorf("Pollard.Verify fail. Was given %d targets but got %d hashes",
-			len(proof.Targets), len(delHashes))
+	for _, target := range targets {
+		pos := target
+		for row := detectRow(target, forestRows); row < forestRows; row++ {
+			pos = parent(pos, forestRows)
+			if _, found := targetSet[pos]; found {
+				return fmt.Errorf("%w. Target %d is an ancestor of target %d",
+					ErrProofMalformed, pos, target)
+			}
+		}
 	}
 
-	rootCandidates := calculateRoots(p.numLeaves, delHashes, proof)
-	if len(rootCandidates) == 0 {
-		return fmt.Errorf("Pollard.Verify fail. No roots calculated "+
-			"but have %d deletions", len(delHashes))
+	return nil
+}
+
+// VerifyAndGetPromotions verifies the proof and returns the positions that the
+// siblings of the deleted leaves will be at after the deletion. Roots that are
+// deleted entirely are not included.
+func (p *Pollard) VerifyAndGetPromotions(delHashes []Hash, proof Proof) ([]uint64, error) {
+	err := p.Verify(delHashes, proof)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyAndGetPromotions fail. Error: %w", err)
 	}
 
-	rootMatches := 0
-	for i := range p.roots {
-		if len(rootCandidates) > rootMatches &&
-			p.roots[len(p.roots)-(i+1)].data == rootCandidates[rootMatches] {
-			rootMatches++
+	forestRows := treeRows(p.numLeaves)
+
+	// Figure out which roots are getting deleted so that they can be
+	// excluded from the promotions.
+	dels := make([]uint64, len(proof.Targets))
+	copy(dels, proof.Targets)
+	sort.Slice(dels, func(a, b int) bool { return dels[a] < dels[b] })
+	dels = deTwin(dels, forestRows)
+
+	_, afterProof := proofAfterDeletion(p.numLeaves, proof)
+
+	promotions := make([]uint64, 0, len(afterProof.Targets))
+	for _, target := range afterProof.Targets {
+		if isRootPosition(target, p.numLeaves, forestRows) &&
+			slices.Contains(dels, target) {
+			continue
 		}
-	}
-	// Error out if all the rootCandidates do not have a corresponding
-	// polnode with the same hash.
-	if len(rootCandidates) != rootMatches {
-		rootHashes := make([]Hash, len(p.roots))
-		for i := range rootHashes {
-			rootHashes[i] = p.roots[i].data
-		}
-		// The proof is invalid because some root candidates were not
-		// included in `roots`.
-		err := fmt.Errorf("Pollard.Verify fail. Have %d roots but only "+
-			"matched %d roots.\nRootcandidates:\n%v\nRoots:\n%v",
-			len(rootCandidates), rootMatches,
-			printHashes(rootCandidates), printHashes(rootHashes))
-		return err
+		promotions = append(promotions, target)
 	}
 
-	return nil
+	return promotions, nil
+}
+
+// calculateRoots calculates and returns the root hashes. An error wrapping
+// ErrProofMalformed is returned if the proof doesn't have enough hashes.
+//
+// The positions of the proof hashes are derived while walking up each row
+// instead of being generated up front with proofPositions. The proof hashes
+// are consumed in order so they MUST be sorted by position.
+func calculateRoots(numLeaves uint64, delHashes []Hash, proof Proof) ([]Hash, error) {
+	return calculateRootsWithRows(DefaultHasher{}, numLeaves, treeRows(numLeaves), delHashes, proof)
+}
+
+// calculateRootsWithRows is calculateRoots for a forest with totalRows that uses
+// the given hasher. The positions in the proof must be in the position space of
+// totalRows.
+func calculateRootsWithRows(hasher Hasher, numLeaves uint64, totalRows uint8,
+	delHashes []Hash, proof Proof) ([]Hash, error) {
+
+	// The background context is never cancelled so the only error is a
+	// malformed proof.
+	roots, _, err := calculateHashes(context.Background(), hasher, numLeaves,
+		totalRows, delHashes, proof, false, nil)
+	return roots, err
 }
 
-// calculateRoots calculates and returns the root hashes.
-func calculateRoots(numLeaves uint64, delHashes []Hash, proof Proof) []Hash {
-	totalRows := treeRows(numLeaves)
+// ctxCheckInterval is how many nodes calculateHashes processes between checking
+// if the context was cancelled.
+const ctxCheckInterval = 1024
+
+// calculateHashes calculates and returns the root hashes like calculateRootsWithRows.
+// If returnIntermediate is true, every node that was calculated while hashing up
+// to the roots is returned as well. The context is checked every
+// ctxCheckInterval hashes and ctx.Err() is returned if it's cancelled. An error
+// wrapping ErrProofMalformed is returned if the proof doesn't have enough hashes.
+//
+// The buffers in scratch are used if scratch isn't nil. The returned roots are
+// then only valid until the next use of the scratch.
+func calculateHashes(ctx context.Context, hasher Hasher, numLeaves uint64, totalRows uint8,
+	delHashes []Hash, proof Proof, returnIntermediate bool,
+	scratch *VerifyScratch) ([]Hash, []hashAndPos, error) {
+
+	if scratch == nil {
+		scratch = &VerifyScratch{
+			roots:      make([]Hash, 0, numRoots(numLeaves)),
+			nextProves: make([]hashAndPos, 0, len(delHashes)),
+		}
+	}
+
+	// Where all the calculated nodes will go to if returnIntermediate is set.
+	var intermediate []hashAndPos
 
 	// Where all the root hashes that we've calculated will go to.
-	calculatedRootHashes := make([]Hash, 0, numRoots(numLeaves))
+	scratch.roots = scratch.roots[:0]
 
 	// Where all the parent hashes we've calculated in a given row will go to.
-	nextProves := make([]hashAndPos, 0, len(delHashes))
+	scratch.nextProves = scratch.nextProves[:0]
 
 	// These are the leaves to be proven. Each represent a position and the
-	// hash of a leaf.
-	toProve := toHashAndPos(proof.Targets, delHashes)
+	// hash of a leaf. No guarantee that the targets and the delHashes are in
+	// order. Sort them before processing.
+	scratch.toProve = scratch.toProve[:0]
+	for i := range delHashes {
+		scratch.toProve = append(scratch.toProve, hashAndPos{delHashes[i], proof.Targets[i]})
+	}
+	slices.SortFunc(scratch.toProve, func(a, b hashAndPos) bool { return a.pos < b.pos })
 
 	// Separate index for the hashes in the passed in proof.
 	proofHashIdx := 0
+	processed := 0
+
+	// Since the targets are sorted, the targets for each row are next to each
+	// other and the rows can be extracted without copying.
+	toProveIdx := 0
 	for row := 0; row <= int(totalRows); row++ {
-		extractedProves := extractRowHash(toProve, totalRows, uint8(row))
+		start := toProveIdx
+		for toProveIdx < len(scratch.toProve) &&
+			detectRow(scratch.toProve[toProveIdx].pos, totalRows) == uint8(row) {
+			toProveIdx++
+		}
 
-		proves := mergeSortedSlicesFunc(nextProves, extractedProves, hashAndPosCmp)
-		nextProves = nextProves[:0]
+		scratch.proves = mergeSortedSlicesFuncInto(scratch.proves[:0], scratch.nextProves,
+			scratch.toProve[start:toProveIdx], hashAndPosCmp)
+		scratch.nextProves = scratch.nextProves[:0]
 
+		proves := scratch.proves
 		for i := 0; i < len(proves); i++ {
 			prove := proves[i]
 
+			processed++
+			if processed%ctxCheckInterval == 0 {
+				select {
+				case <-ctx.Done():
+					return nil, nil, ctx.Err()
+				default:
+				}
+			}
+
 			// This means we hashed all the way to the top of this subtree.
 			if isRootPosition(prove.pos, numLeaves, totalRows) {
-				calculatedRootHashes = append(calculatedRootHashes, prove.hash)
+				scratch.roots = append(scratch.roots, prove.hash)
 				continue
 			}
 
 			// Check if the next prove is the sibling of this prove.
 			if i+1 < len(proves) && rightSib(prove.pos) == proves[i+1].pos {
 				nextProve := hashAndPos{
-					hash: parentHash(prove.hash, proves[i+1].hash),
+					hash: hasher.ParentHash(prove.hash, proves[i+1].hash),
 					pos:  parent(prove.pos, totalRows),
 				}
-				nextProves = append(nextProves, nextProve)
+				scratch.nextProves = append(scratch.nextProves, nextProve)
+				if returnIntermediate {
+					intermediate = append(intermediate, nextProve)
+				}
 
 				i++ // Increment one more since we procesed another prove.
 			} else {
 				// If the next prove isn't the sibling of this prove, we fetch
 				// the next proof hash to calculate the parent.
+				if proofHashIdx >= len(proof.Proof) {
+					return nil, nil, fmt.Errorf("%w. Proof has too few hashes. "+
+						"Ran out after using all %d", ErrProofMalformed, len(proof.Proof))
+				}
 				hash := proof.Proof[proofHashIdx]
 				proofHashIdx++
 
 				nextProve := hashAndPos{pos: parent(prove.pos, totalRows)}
 				if isLeftNiece(prove.pos) {
-					nextProve.hash = parentHash(prove.hash, hash)
+					nextProve.hash = hasher.ParentHash(prove.hash, hash)
 				} else {
-					nextProve.hash = parentHash(hash, prove.hash)
+					nextProve.hash = hasher.ParentHash(hash, prove.hash)
 				}
 
-				nextProves = append(nextProves, nextProve)
+				scratch.nextProves = append(scratch.nextProves, nextProve)
+				if returnIntermediate {
+					intermediate = append(intermediate, nextProve)
+				}
 			}
 		}
 	}
 
-	return calculatedRootHashes
+	return scratch.roots, intermediate, nil
 }
 
 func mergeSortedSlicesFunc[E any](a, b []E, cmp func(E, E) int) (c []E) {
@@ -280,8 +1460,34 @@ func mergeSortedSlicesFunc[E any](a, b []E, cmp func(E, E) int) (c []E) {
 	return
 }
 
+// mergeSortedSlicesFuncInto is mergeSortedSlicesFunc but appends the merged
+// elements to dst instead of allocating a new slice.
+func mergeSortedSlicesFuncInto[E any](dst, a, b []E, cmp func(E, E) int) []E {
+	idxa, idxb := 0, 0
+	for idxa < len(a) && idxb < len(b) {
+		switch cmp(a[idxa], b[idxb]) {
+		case -1: // a is less so append that
+			dst = append(dst, a[idxa])
+			idxa++
+		case 1: // b is less so append that
+			dst = append(dst, b[idxb])
+			idxb++
+		default: // they're equal
+			dst = append(dst, a[idxa])
+			idxa++
+			idxb++
+		}
+	}
+
+	// Append the remainder of whichever one is left.
+	dst = append(dst, a[idxa:]...)
+	dst = append(dst, b[idxb:]...)
+
+	return dst
+}
+
 func extractRowHash(toProve []hashAndPos, forestRows, rowToExtract uint8) []hashAndPos {
-	if len(toProve) < 0 {
+	if len(toProve) == 0 {
 		return []hashAndPos{}
 	}
 
@@ -318,7 +1524,7 @@ func extractRowHash(toProve []hashAndPos, forestRows, rowToExtract uint8) []hash
 }
 
 func extractRowNode(toProve []nodeAndPos, forestRows, rowToExtract uint8) []nodeAndPos {
-	if len(toProve) < 0 {
+	if len(toProve) == 0 {
 		return []nodeAndPos{}
 	}
 
@@ -490,21 +1696,7 @@ func GetMissingPositions(numLeaves uint64, proof Proof, desiredTargets []uint64)
 	sort.Slice(desiredTargets, func(a, b int) bool { return desiredTargets[a] < desiredTargets[b] })
 
 	// Check for the targets that we already have.
-	targIdx := 0
-	for i := 0; i < len(desiredTargets); i++ {
-		if targIdx >= len(targets) {
-			break
-		}
-		if desiredTargets[i] == targets[targIdx] {
-			desiredTargets = append(desiredTargets[:i], desiredTargets[i+1:]...)
-			i--
-		} else if desiredTargets[i] < targets[targIdx] {
-			continue
-		} else if desiredTargets[i] > targets[targIdx] {
-			targIdx++
-			i--
-		}
-	}
+	desiredTargets = subtractSortedSlice(desiredTargets, targets)
 
 	// Return early if we don't have any targets to prove.
 	if len(desiredTargets) <= 0 {
@@ -544,6 +1736,481 @@ func GetMissingPositions(numLeaves uint64, proof Proof, desiredTargets []uint64)
 	return desiredPositions
 }
 
+// GetMissingHashes returns the positions that are missing to prove the wanted hashes
+// given that the proof for the have hashes are already present. Returns an error if
+// any of the hashes are not present in the accumulator.
+func (p *Pollard) GetMissingHashes(have []Hash, want []Hash) ([]uint64, error) {
+	havePositions, err := p.hashesToPositions(have)
+	if err != nil {
+		return nil, fmt.Errorf("GetMissingHashes fail. Error: %w", err)
+	}
+	wantPositions, err := p.hashesToPositions(want)
+	if err != nil {
+		return nil, fmt.Errorf("GetMissingHashes fail. Error: %w", err)
+	}
+
+	return GetMissingPositions(p.numLeaves, Proof{Targets: havePositions}, wantPositions), nil
+}
+
+// ProofRequest is a request for the hashes at the given positions. A client that
+// already has a proof for some leaves is able to ask for only the hashes it's
+// missing to prove more leaves by requesting the positions returned by
+// GetMissingPositions.
+type ProofRequest struct {
+	Positions []uint64
+}
+
+// AnswerProofRequest returns the hashes at the requested positions in the same
+// order as the positions. An error is returned if the pollard doesn't have the
+// hash for any of the positions. The returned hashes are able to be added to a
+// proof with MergeProofResponse.
+func (p *Pollard) AnswerProofRequest(req ProofRequest) ([]Hash, error) {
+	hashes := make([]Hash, len(req.Positions))
+	for i, pos := range req.Positions {
+		n, _, _, err := p.getNode(pos)
+		if err != nil {
+			return nil, fmt.Errorf("AnswerProofRequest fail. Error: %v", err)
+		}
+		if n == nil || n.data == empty {
+			return nil, fmt.Errorf("AnswerProofRequest fail. %w. Don't have "+
+				"the hash for position %d", ErrHashNotFound, pos)
+		}
+		hashes[i] = n.data
+	}
+
+	return hashes, nil
+}
+
+// TargetsForHashes returns the positions of the passed in hashes. The returned
+// targets are in the same order as the hashes and are the same as the targets
+// that Prove would return for the hashes. An error wrapping ErrHashNotFound is
+// returned if any of the hashes are not cached in the pollard. This lets the
+// targets of a block be figured out before fetching any proof hashes.
+func (p *Pollard) TargetsForHashes(hashes []Hash) ([]uint64, error) {
+	targets, err := p.hashesToPositions(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("TargetsForHashes fail. Error: %w", err)
+	}
+
+	return targets, nil
+}
+
+// hashesToPositions returns the positions of the passed in hashes. Returns an error
+// if any of the hashes are not cached in the node map.
+func (p *Pollard) hashesToPositions(hashes []Hash) ([]uint64, error) {
+	positions := make([]uint64, len(hashes))
+	for i, hash := range hashes {
+		node, ok := p.nodeMap[hash.mini()]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrHashNotFound,
+				hex.EncodeToString(hash[:]))
+		}
+		positions[i] = p.calculatePosition(node)
+	}
+
+	return positions, nil
+}
+
+// SameRootEpoch returns true if the two proofs were built against the same roots
+// for the subtrees they both prove. The proof hashes at the highest row of each
+// subtree are compared as they only change when the subtrees are merged. false
+// is returned if the proofs don't have any subtrees in common or if the highest
+// proof positions of a common subtree differ.
+//
+// NOTE Only the root adjacent proof hashes are compared. The proofs are NOT verified.
+func SameRootEpoch(numLeaves uint64, proofA, proofB Proof) bool {
+	topA, ok := topProofHashes(numLeaves, proofA)
+	if !ok {
+		return false
+	}
+	topB, ok := topProofHashes(numLeaves, proofB)
+	if !ok {
+		return false
+	}
+
+	matched := false
+	for tree, a := range topA {
+		b, found := topB[tree]
+		if !found {
+			continue
+		}
+		if a != b {
+			return false
+		}
+		matched = true
+	}
+
+	return matched
+}
+
+// topProofHashes returns the proof hash at the highest row for each of the subtrees
+// in the proof. Returns false if the proof doesn't have the right amount of proof
+// hashes for the given numLeaves.
+func topProofHashes(numLeaves uint64, proof Proof) (map[uint8]hashAndPos, bool) {
+	forestRows := treeRows(numLeaves)
+
+	targets := make([]uint64, len(proof.Targets))
+	copy(targets, proof.Targets)
+	sort.Slice(targets, func(a, b int) bool { return targets[a] < targets[b] })
+
+	positions, _ := proofPositions(targets, numLeaves, forestRows)
+	if len(positions) != len(proof.Proof) {
+		return nil, false
+	}
+
+	tops := make(map[uint8]hashAndPos)
+	for i, pos := range positions {
+		tree, _, _, err := detectOffset(pos, numLeaves)
+		if err != nil {
+			return nil, false
+		}
+
+		top, found := tops[tree]
+		if !found || detectRow(pos, forestRows) > detectRow(top.pos, forestRows) {
+			tops[tree] = hashAndPos{proof.Proof[i], pos}
+		}
+	}
+
+	return tops, true
+}
+
+// ProofUpdate is a change to a cache of proof hashes keyed by their positions.
+type ProofUpdate struct {
+	// Positions are the positions of the proof hashes that were added or
+	// changed.
+	Positions []uint64
+
+	// Hashes are the new hashes for the Positions.
+	Hashes []Hash
+
+	// Removed are the positions that are no longer needed.
+	Removed []uint64
+}
+
+// Apply applies the update to the cache. The removals are applied before the
+// additions.
+func (u *ProofUpdate) Apply(cache map[uint64]Hash) {
+	for _, pos := range u.Removed {
+		delete(cache, pos)
+	}
+	for i, pos := range u.Positions {
+		cache[pos] = u.Hashes[i]
+	}
+}
+
+// CombineProofUpdates collapses the updates into a single update that has the
+// same result as applying the updates one by one in order. Only the last change
+// to each position is kept.
+func CombineProofUpdates(updates []ProofUpdate) ProofUpdate {
+	// The last hash set for each position. Removed positions are set to nil.
+	last := make(map[uint64]*Hash)
+	for _, update := range updates {
+		for _, pos := range update.Removed {
+			last[pos] = nil
+		}
+		for i, pos := range update.Positions {
+			hash := update.Hashes[i]
+			last[pos] = &hash
+		}
+	}
+
+	positions := make([]uint64, 0, len(last))
+	for pos := range last {
+		positions = append(positions, pos)
+	}
+	slices.Sort(positions)
+
+	var combined ProofUpdate
+	for _, pos := range positions {
+		hash := last[pos]
+		if hash == nil {
+			combined.Removed = append(combined.Removed, pos)
+			continue
+		}
+		combined.Positions = append(combined.Positions, pos)
+		combined.Hashes = append(combined.Hashes, *hash)
+	}
+
+	return combined
+}
+
+// SerializeSize returns the number of bytes the update takes up when each
+// position is 8 bytes and each hash is 32 bytes.
+func (u *ProofUpdate) SerializeSize() int {
+	return len(u.Positions)*(8+32) + len(u.Removed)*8
+}
+
+// ProofUpdateBetween returns the update that turns the old proof hash cache into
+// the new one.
+func ProofUpdateBetween(old, new map[uint64]Hash) ProofUpdate {
+	var update ProofUpdate
+	for pos := range old {
+		if _, found := new[pos]; !found {
+			update.Removed = append(update.Removed, pos)
+		}
+	}
+	for pos, hash := range new {
+		oldHash, found := old[pos]
+		if !found || oldHash != hash {
+			update.Positions = append(update.Positions, pos)
+			update.Hashes = append(update.Hashes, hash)
+		}
+	}
+
+	return update
+}
+
+// ModifyProofAdd returns the proof updated for the addHashes being added to the
+// accumulator. The stump must be the accumulator state that the proof is for and
+// the returned proof is for the accumulator after the additions. The roots of the
+// stump are needed as the trees of the targets may be merged with the other roots
+// as leaves are added.
+//
+// If a tree is moved up over an empty root, the targets and the proof hashes under
+// it are moved up along with the tree.
+//
+// An error is returned if the proof doesn't match the numLeaves of the stump.
+func ModifyProofAdd(proof Proof, addHashes []Hash, stump Stump) (Proof, error) {
+	return ModifyProofAddWithHasher(DefaultHasher{}, proof, addHashes, stump)
+}
+
+// ModifyProofAddWithHasher is ModifyProofAdd but hashes with the given hasher. The
+// hasher must be the hasher of the accumulator the proof is for.
+func ModifyProofAddWithHasher(hasher Hasher, proof Proof, addHashes []Hash,
+	stump Stump) (Proof, error) {
+
+	if len(stump.Roots) != int(numRoots(stump.NumLeaves)) {
+		return Proof{}, fmt.Errorf("ModifyProofAdd fail. Have %d roots but "+
+			"expected %d roots for %d leaves", len(stump.Roots),
+			numRoots(stump.NumLeaves), stump.NumLeaves)
+	}
+	if len(addHashes) == 0 || len(proof.Targets) == 0 {
+		return proof, nil
+	}
+
+	// Use the rows of the forest after the additions for all the positions so
+	// that the positions don't change as the forest grows.
+	oldRows := treeRows(stump.NumLeaves)
+	newNumLeaves := stump.NumLeaves + uint64(len(addHashes))
+	totalRows := treeRows(newNumLeaves)
+
+	oldPositions, _ := proofPositions(sortedTargets(proof.Targets), stump.NumLeaves, oldRows)
+	if len(oldPositions) != len(proof.Proof) {
+		return Proof{}, fmt.Errorf("ModifyProofAdd fail. %w. Expected %d proof "+
+			"hashes but got %d", ErrProofMalformed, len(oldPositions), len(proof.Proof))
+	}
+	known := make(map[uint64]Hash, len(oldPositions)+int(totalRows)*2)
+	for i, pos := range oldPositions {
+		known[translatePos(pos, oldRows, totalRows)] = proof.Proof[i]
+	}
+
+	targets := TranslatePositions(proof.Targets, oldRows, totalRows)
+
+	type rootNode struct {
+		hash      Hash
+		pos       uint64
+		hasTarget bool
+	}
+	rootPositions := RootPositions(stump.NumLeaves, oldRows)
+	roots := make([]rootNode, len(stump.Roots))
+	for i := range roots {
+		roots[i] = rootNode{hash: stump.Roots[i], pos: translatePos(rootPositions[i], oldRows, totalRows)}
+		for _, target := range targets {
+			if isAncestor(roots[i].pos, target, totalRows) {
+				roots[i].hasTarget = true
+				break
+			}
+		}
+	}
+
+	// Add the leaves the same way stumpAdd does while remembering the hashes
+	// of the nodes that get merged as they may be needed in the proof.
+	numLeaves := stump.NumLeaves
+	for _, add := range addHashes {
+		newRoot := rootNode{hash: add, pos: numLeaves}
+		for h := uint8(0); (numLeaves>>h)&1 == 1; h++ {
+			root := roots[len(roots)-1]
+			roots = roots[:len(roots)-1]
+
+			// If the root is empty, the new root and everything under it
+			// moves up by one row.
+			if root.hash == empty {
+				if newRoot.hasTarget {
+					for i, target := range targets {
+						if isAncestor(newRoot.pos, target, totalRows) {
+							targets[i], _ = calcNextPosition(target, newRoot.pos, totalRows)
+						}
+					}
+				}
+
+				var moved []hashAndPos
+				for pos, hash := range known {
+					if isAncestor(newRoot.pos, pos, totalRows) {
+						moved = append(moved, hashAndPos{hash, pos})
+						delete(known, pos)
+					}
+				}
+				for _, node := range moved {
+					nextPos, _ := calcNextPosition(node.pos, newRoot.pos, totalRows)
+					known[nextPos] = node.hash
+				}
+
+				newRoot.pos = parent(newRoot.pos, totalRows)
+				continue
+			}
+
+			known[root.pos] = root.hash
+			known[newRoot.pos] = newRoot.hash
+			newRoot = rootNode{
+				hash:      hasher.ParentHash(root.hash, newRoot.hash),
+				pos:       parent(root.pos, totalRows),
+				hasTarget: root.hasTarget || newRoot.hasTarget,
+			}
+		}
+		roots = append(roots, newRoot)
+		numLeaves++
+	}
+
+	positions, _ := proofPositions(sortedTargets(targets), newNumLeaves, totalRows)
+	updated := Proof{Targets: targets, Proof: make([]Hash, len(positions))}
+	for i, pos := range positions {
+		hash, found := known[pos]
+		if !found {
+			return Proof{}, fmt.Errorf("ModifyProofAdd fail. Missing the hash "+
+				"for position %d", pos)
+		}
+		updated.Proof[i] = hash
+	}
+
+	return updated, nil
+}
+
+// MergeProofs returns a single proof for all the targets in the two proofs along
+// with the hashes of the targets. The targets in the returned proof are sorted and
+// targets present in both proofs are only included once. An error is returned if
+// the two proofs imply different hashes for the same position.
+func MergeProofs(numLeaves uint64, a, b Proof, aHashes, bHashes []Hash) (Proof, []Hash, error) {
+	aKnown, err := knownPositions(DefaultHasher{}, numLeaves, a, aHashes)
+	if err != nil {
+		return Proof{}, nil, fmt.Errorf("MergeProofs fail. Proof a: %v", err)
+	}
+	bKnown, err := knownPositions(DefaultHasher{}, numLeaves, b, bHashes)
+	if err != nil {
+		return Proof{}, nil, fmt.Errorf("MergeProofs fail. Proof b: %v", err)
+	}
+
+	for pos, hash := range bKnown {
+		aHash, found := aKnown[pos]
+		if found && aHash != hash {
+			return Proof{}, nil, fmt.Errorf("MergeProofs fail. Conflicting "+
+				"hashes %s and %s for position %d",
+				hex.EncodeToString(aHash[:]), hex.EncodeToString(hash[:]), pos)
+		}
+		aKnown[pos] = hash
+	}
+
+	targets := append(sortedTargets(a.Targets), b.Targets...)
+	slices.Sort(targets)
+	targets = slices.Compact(targets)
+
+	merged := Proof{Targets: targets}
+	delHashes := make([]Hash, len(targets))
+	for i, target := range targets {
+		delHashes[i] = aKnown[target]
+	}
+
+	positions, _ := proofPositions(targets, numLeaves, treeRows(numLeaves))
+	merged.Proof = make([]Hash, len(positions))
+	for i, pos := range positions {
+		hash, found := aKnown[pos]
+		if !found {
+			return Proof{}, nil, fmt.Errorf("MergeProofs fail. Missing the "+
+				"hash for position %d", pos)
+		}
+		merged.Proof[i] = hash
+	}
+
+	return merged, delHashes, nil
+}
+
+// SubProof returns a proof for only the keepTargets along with the hashes of those
+// targets. delHashes must be the hashes of the targets of the proof. The targets in
+// the returned proof are sorted. An error is returned if any of the keepTargets
+// aren't in the proof.
+func (p *Proof) SubProof(numLeaves uint64, delHashes []Hash, keepTargets []uint64) (Proof, []Hash, error) {
+	known, err := knownPositions(DefaultHasher{}, numLeaves, *p, delHashes)
+	if err != nil {
+		return Proof{}, nil, fmt.Errorf("SubProof fail. %v", err)
+	}
+
+	targets := sortedTargets(keepTargets)
+	targets = slices.Compact(targets)
+	if len(targets) == 0 {
+		return Proof{}, nil, nil
+	}
+
+	keepHashes := make([]Hash, len(targets))
+	for i, target := range targets {
+		if !slices.Contains(p.Targets, target) {
+			return Proof{}, nil, fmt.Errorf("SubProof fail. Target %d isn't "+
+				"in the proof", target)
+		}
+		keepHashes[i] = known[target]
+	}
+
+	positions, _ := proofPositions(targets, numLeaves, treeRows(numLeaves))
+	sub := Proof{Targets: targets, Proof: make([]Hash, len(positions))}
+	for i, pos := range positions {
+		hash, found := known[pos]
+		if !found {
+			return Proof{}, nil, fmt.Errorf("SubProof fail. Missing the hash "+
+				"for position %d", pos)
+		}
+		sub.Proof[i] = hash
+	}
+
+	return sub, keepHashes, nil
+}
+
+// knownPositions returns the hashes of every position that the proof has or is
+// able to calculate. The parent hashes are calculated with the given hasher.
+func knownPositions(hasher Hasher, numLeaves uint64, proof Proof, delHashes []Hash) (map[uint64]Hash, error) {
+	if len(delHashes) != len(proof.Targets) {
+		return nil, fmt.Errorf("was given %d targets but got %d hashes",
+			len(proof.Targets), len(delHashes))
+	}
+
+	forestRows := treeRows(numLeaves)
+	positions, _ := proofPositions(sortedTargets(proof.Targets), numLeaves, forestRows)
+	if len(positions) != len(proof.Proof) {
+		return nil, fmt.Errorf("expected %d proof hashes but got %d",
+			len(positions), len(proof.Proof))
+	}
+
+	_, intermediate, err := calculateHashes(context.Background(), hasher,
+		numLeaves, forestRows, delHashes, proof, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[uint64]Hash, len(delHashes)+len(positions)+len(intermediate))
+	for i, target := range proof.Targets {
+		hash, found := known[target]
+		if found && hash != delHashes[i] {
+			return nil, fmt.Errorf("conflicting hashes for target %d", target)
+		}
+		known[target] = delHashes[i]
+	}
+	for i, pos := range positions {
+		known[pos] = proof.Proof[i]
+	}
+	for _, node := range intermediate {
+		known[node.pos] = node.hash
+	}
+
+	return known, nil
+}
+
 func AddProof(origProof, newProof Proof, numLeaves uint64) Proof {
 	origProof.Targets = append(origProof.Targets, newProof.Targets...)
 
@@ -568,6 +2235,67 @@ func AddProof(origProof, newProof Proof, numLeaves uint64) Proof {
 	return origProof
 }
 
+// MergeProofResponse adds newTargets to origProof using the hashes returned for a
+// ProofRequest and returns the combined proof. The new targets are appended after
+// the targets of origProof so the hashes of the new targets should be appended
+// after the hashes of the original targets when verifying the returned proof.
+//
+// hashes must be the hashes for the positions returned by
+// GetMissingPositions(numLeaves, origProof, newTargets) in the same order.
+// origProof must be a valid proof and newTargets must not have any targets that
+// are already in origProof.
+func MergeProofResponse(origProof Proof, newTargets []uint64, hashes []Hash,
+	numLeaves uint64) (Proof, error) {
+
+	targets := make([]uint64, 0, len(origProof.Targets)+len(newTargets))
+	targets = append(targets, origProof.Targets...)
+	for _, target := range newTargets {
+		if slices.Contains(origProof.Targets, target) {
+			return Proof{}, fmt.Errorf("MergeProofResponse fail. Target %d is already "+
+				"in the original proof", target)
+		}
+		targets = append(targets, target)
+	}
+
+	forestRows := treeRows(numLeaves)
+	// GetMissingPositions sorts the desired targets so pass in a copy.
+	desired := make([]uint64, len(newTargets))
+	copy(desired, newTargets)
+	missing := GetMissingPositions(numLeaves, origProof, desired)
+	if len(missing) != len(hashes) {
+		return Proof{}, fmt.Errorf("MergeProofResponse fail. %w. Expected %d hashes for "+
+			"the new targets but got %d", ErrProofMalformed, len(missing), len(hashes))
+	}
+
+	origPositions, _ := proofPositions(sortedTargets(origProof.Targets), numLeaves, forestRows)
+	if len(origPositions) != len(origProof.Proof) {
+		return Proof{}, fmt.Errorf("MergeProofResponse fail. %w. Expected %d hashes "+
+			"in the original proof but got %d", ErrProofMalformed,
+			len(origPositions), len(origProof.Proof))
+	}
+
+	known := make(map[uint64]Hash, len(origProof.Proof)+len(missing))
+	for i, pos := range origPositions {
+		known[pos] = origProof.Proof[i]
+	}
+	for i, pos := range missing {
+		known[pos] = hashes[i]
+	}
+
+	positions, _ := proofPositions(sortedTargets(targets), numLeaves, forestRows)
+	proofHashes := make([]Hash, len(positions))
+	for i, pos := range positions {
+		hash, found := known[pos]
+		if !found {
+			return Proof{}, fmt.Errorf("MergeProofResponse fail. %w. Missing the hash "+
+				"for position %d", ErrProofMalformed, pos)
+		}
+		proofHashes[i] = hash
+	}
+
+	return Proof{Targets: targets, Proof: proofHashes}, nil
+}
+
 // getRemovePositions removes all the duplicates from removePositions that also exist in wantPositions.
 func getRemovePositions(wantPositions, removePositions []uint64) []uint64 {
 	sort.Slice(wantPositions, func(a, b int) bool { return wantPositions[a] < wantPositions[b] })
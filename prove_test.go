@@ -0,0 +1,2140 @@
+package utreexo
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// calculateRootsWithPositions is the reference implementation of calculateRoots.
+// It materializes all the proof positions up front with proofPositions and then
+// hashes up to the roots.
+func calculateRootsWithPositions(numLeaves uint64, delHashes []Hash, proof Proof) []Hash {
+	forestRows := treeRows(numLeaves)
+
+	targets := make([]uint64, len(proof.Targets))
+	copy(targets, proof.Targets)
+	sort.Slice(targets, func(a, b int) bool { return targets[a] < targets[b] })
+
+	positions, _ := proofPositions(targets, numLeaves, forestRows)
+
+	hashes := make(map[uint64]Hash, len(positions)+len(targets))
+	for i, pos := range positions {
+		hashes[pos] = proof.Proof[i]
+	}
+	for i, target := range proof.Targets {
+		hashes[target] = delHashes[i]
+	}
+
+	var roots []Hash
+	for row := uint8(0); row <= forestRows; row++ {
+		rowPositions := []uint64{}
+		for pos := range hashes {
+			if detectRow(pos, forestRows) == row {
+				rowPositions = append(rowPositions, pos)
+			}
+		}
+		sort.Slice(rowPositions, func(a, b int) bool { return rowPositions[a] < rowPositions[b] })
+
+		for _, pos := range rowPositions {
+			if isRootPosition(pos, numLeaves, forestRows) {
+				roots = append(roots, hashes[pos])
+				continue
+			}
+
+			// Only hash from the left sibling so that each parent is
+			// calculated once.
+			if !isLeftNiece(pos) {
+				continue
+			}
+			hashes[parent(pos, forestRows)] = parentHash(hashes[pos], hashes[sibling(pos)])
+		}
+	}
+
+	return roots
+}
+
+func FuzzCalculateRoots(f *testing.F) {
+	var tests = []struct {
+		startLeaves uint32
+		delCount    uint32
+		seed        int64
+	}{
+		{8, 3, 0},
+		{6, 5, 0},
+		{31, 10, 0},
+		{100, 33, 424},
+	}
+	for _, test := range tests {
+		f.Add(test.startLeaves, test.delCount, test.seed)
+	}
+
+	f.Fuzz(func(t *testing.T, startLeaves uint32, delCount uint32, seed int64) {
+		// Set seed to make sure the test is reproducible.
+		rand.Seed(seed)
+
+		// delCount must be less than the current number of leaves.
+		if delCount > startLeaves || startLeaves > 1000 {
+			return
+		}
+
+		p := NewAccumulator(true)
+		leaves, delHashes, _ := getAddsAndDels(uint32(p.numLeaves), startLeaves, delCount)
+		err := p.Modify(leaves, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proof.Targets) != len(delHashes) {
+			return
+		}
+
+		expected := calculateRootsWithPositions(p.numLeaves, delHashes, proof)
+		got, err := calculateRoots(p.numLeaves, delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(expected) == 0 && len(got) == 0 {
+			return
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("FuzzCalculateRoots fail. Expected roots:\n%s\ngot:\n%s",
+				printHashes(expected), printHashes(got))
+		}
+	})
+}
+
+func BenchmarkCalculateRoots(b *testing.B) {
+	rand.Seed(0)
+
+	p := NewAccumulator(true)
+	leaves, delHashes, _ := getAddsAndDels(uint32(p.numLeaves), 10000, 2000)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("lazy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			calculateRoots(p.numLeaves, delHashes, proof)
+		}
+	})
+
+	b.Run("positions", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			calculateRootsWithPositions(p.numLeaves, delHashes, proof)
+		}
+	})
+}
+
+func TestVerifyAndGetPromotions(t *testing.T) {
+	t.Parallel()
+
+	for _, seed := range []int64{0, 1, 2, 3, 424} {
+		rand.Seed(seed)
+
+		p := NewAccumulator(true)
+		leaves, delHashes, _ := getAddsAndDels(uint32(p.numLeaves), 31, 9)
+		err := p.Modify(leaves, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		promotions, err := p.VerifyAndGetPromotions(delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// An invalid proof should error out.
+		badHashes := make([]Hash, len(delHashes))
+		copy(badHashes, delHashes)
+		badHashes[0][0] ^= 0xFF
+		_, err = p.VerifyAndGetPromotions(badHashes, proof)
+		if err == nil {
+			t.Fatalf("TestVerifyAndGetPromotions fail. Expected an error for an invalid proof")
+		}
+
+		err = p.Modify(nil, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The promoted positions should hold the siblings that were in the proof.
+		for _, pos := range promotions {
+			hash := p.getHash(pos)
+			if !slices.Contains(proof.Proof, hash) {
+				t.Fatalf("TestVerifyAndGetPromotions fail with seed %d. Position %d "+
+					"holds %x which isn't a promoted sibling", seed, pos, hash)
+			}
+		}
+	}
+}
+
+func TestVerifyAncestorTargets(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 8, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 14
+	// |---------------\
+	// 12              13
+	// |-------\       |-------\
+	// 08      09      10      11
+	// |---\   |---\   |---\   |---\
+	// 00  01  02  03  04  05  06  07
+	//
+	// Prove 00 and 12 at the same time.
+	proof, err := p.Prove([]Hash{leaves[0].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Targets = append(proof.Targets, 12)
+	proof.Proof = append(proof.Proof, p.getHash(13))
+	delHashes := []Hash{leaves[0].Hash, p.getHash(12)}
+
+	err = p.Verify(delHashes, proof)
+	if err == nil {
+		t.Fatalf("TestVerifyAncestorTargets fail. Expected an error from Pollard.Verify")
+	}
+
+	stump := Stump{p.GetRoots(), p.numLeaves}
+	_, err = StumpVerify(stump, delHashes, proof)
+	if err == nil {
+		t.Fatalf("TestVerifyAncestorTargets fail. Expected an error from StumpVerify")
+	}
+}
+
+func TestVerifyWithRows(t *testing.T) {
+	t.Parallel()
+
+	rand.Seed(0)
+
+	p := NewAccumulator(true)
+	leaves, delHashes, _ := getAddsAndDels(uint32(p.numLeaves), 13, 5)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forestRows := treeRows(p.numLeaves)
+	for totalRows := forestRows; totalRows < forestRows+4; totalRows++ {
+		translated := Proof{TranslatePositions(proof.Targets, forestRows, totalRows), proof.Proof}
+
+		err = VerifyWithRows(p.numLeaves, totalRows, p.GetRoots(), delHashes, translated)
+		if err != nil {
+			t.Fatalf("TestVerifyWithRows fail for totalRows %d. Error: %v",
+				totalRows, err)
+		}
+
+		badHashes := make([]Hash, len(delHashes))
+		copy(badHashes, delHashes)
+		badHashes[0][0] ^= 0xFF
+		err = VerifyWithRows(p.numLeaves, totalRows, p.GetRoots(), badHashes, translated)
+		if err == nil {
+			t.Fatalf("TestVerifyWithRows fail for totalRows %d. Expected an error "+
+				"for an invalid proof", totalRows)
+		}
+	}
+
+	err = VerifyWithRows(p.numLeaves, forestRows-1, p.GetRoots(), delHashes, proof)
+	if err == nil {
+		t.Fatalf("TestVerifyWithRows fail. Expected an error for too few rows")
+	}
+}
+
+func TestExtractRowEmpty(t *testing.T) {
+	t.Parallel()
+
+	for row := uint8(0); row <= 3; row++ {
+		hashes := extractRowHash([]hashAndPos{}, 3, row)
+		if hashes == nil || len(hashes) != 0 {
+			t.Fatalf("TestExtractRowEmpty fail. Expected an empty slice "+
+				"from extractRowHash, got %v", hashes)
+		}
+		hashes = extractRowHash(nil, 3, row)
+		if hashes == nil || len(hashes) != 0 {
+			t.Fatalf("TestExtractRowEmpty fail. Expected an empty slice "+
+				"from extractRowHash, got %v", hashes)
+		}
+
+		nodes := extractRowNode([]nodeAndPos{}, 3, row)
+		if nodes == nil || len(nodes) != 0 {
+			t.Fatalf("TestExtractRowEmpty fail. Expected an empty slice "+
+				"from extractRowNode, got %v", nodes)
+		}
+		nodes = extractRowNode(nil, 3, row)
+		if nodes == nil || len(nodes) != 0 {
+			t.Fatalf("TestExtractRowEmpty fail. Expected an empty slice "+
+				"from extractRowNode, got %v", nodes)
+		}
+	}
+}
+
+func TestGetMissingHashes(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 15, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		have []uint64
+		want []uint64
+	}{
+		{[]uint64{0}, []uint64{1}},
+		{[]uint64{0}, []uint64{2, 7}},
+		{[]uint64{0, 5, 8}, []uint64{3, 9, 14}},
+		{nil, []uint64{4, 10}},
+	}
+
+	for _, test := range tests {
+		have := make([]Hash, len(test.have))
+		for i, pos := range test.have {
+			have[i] = leaves[pos].Hash
+		}
+		want := make([]Hash, len(test.want))
+		for i, pos := range test.want {
+			want[i] = leaves[pos].Hash
+		}
+
+		got, err := p.GetMissingHashes(have, want)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantPositions := make([]uint64, len(test.want))
+		copy(wantPositions, test.want)
+		expected := GetMissingPositions(p.numLeaves, Proof{Targets: test.have}, wantPositions)
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("TestGetMissingHashes fail. Expected %v, got %v", expected, got)
+		}
+	}
+
+	_, err = p.GetMissingHashes(nil, []Hash{{0xFF}})
+	if err == nil {
+		t.Fatalf("TestGetMissingHashes fail. Expected an error for a hash " +
+			"that isn't in the accumulator")
+	}
+}
+
+func TestSameRootEpoch(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 12, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofA, err := p.Prove([]Hash{leaves[0].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofB, err := p.Prove([]Hash{leaves[2].Hash, leaves[3].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SameRootEpoch(p.numLeaves, proofA, proofB) {
+		t.Fatalf("TestSameRootEpoch fail. Expected proofs from the same state " +
+			"to be from the same epoch")
+	}
+
+	// Proofs that don't share any subtrees aren't comparable.
+	proofC, err := p.Prove([]Hash{leaves[9].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SameRootEpoch(p.numLeaves, proofA, proofC) {
+		t.Fatalf("TestSameRootEpoch fail. Expected proofs with no common " +
+			"subtrees to not be from the same epoch")
+	}
+
+	// Adding 4 leaves merges the subtrees into one.
+	adds, _, _ := getAddsAndDels(uint32(p.numLeaves), 4, 0)
+	err = p.Modify(adds, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofD, err := p.Prove([]Hash{leaves[2].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SameRootEpoch(p.numLeaves, proofA, proofD) {
+		t.Fatalf("TestSameRootEpoch fail. Expected proofs from before and " +
+			"after a merge to not be from the same epoch")
+	}
+}
+
+func TestProveWithFetcher(t *testing.T) {
+	t.Parallel()
+
+	full := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(full.numLeaves), 16, 0)
+	err := full.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher := func(positions []uint64) ([]Hash, error) {
+		hashes := make([]Hash, len(positions))
+		for i, pos := range positions {
+			hashes[i] = full.getHash(pos)
+		}
+		return hashes, nil
+	}
+
+	// Remove the sibling of leaf 0 so that the pollard can't prove it on its own.
+	p := full.Clone()
+	sib, _, _, err := p.getNode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sib.aunt.rNiece = nil
+	sib.aunt = nil
+
+	proveHashes := []Hash{leaves[0].Hash, leaves[9].Hash}
+	_, err = p.Prove(proveHashes)
+	if !errors.Is(err, ErrHashNotFound) {
+		t.Fatalf("TestProveWithFetcher fail. Expected Prove to fail with %v, got %v",
+			ErrHashNotFound, err)
+	}
+	_, err = p.ProveSingle(leaves[0].Hash)
+	if !errors.Is(err, ErrHashNotFound) {
+		t.Fatalf("TestProveWithFetcher fail. Expected ProveSingle to fail with %v, got %v",
+			ErrHashNotFound, err)
+	}
+
+	// A fetcher returning bad hashes should error.
+	_, err = p.ProveWithFetcher(proveHashes, func(positions []uint64) ([]Hash, error) {
+		return make([]Hash, len(positions)), nil
+	})
+	if err == nil {
+		t.Fatalf("TestProveWithFetcher fail. Expected an error with a bad fetcher")
+	}
+
+	proof, err := p.ProveWithFetcher(proveHashes, fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = full.Verify(proveHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fetched hash should've been cached.
+	cachedProof, err := p.Prove(proveHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(proof, cachedProof) {
+		t.Fatalf("TestProveWithFetcher fail. Expected proof %s, got %s",
+			proof.String(), cachedProof.String())
+	}
+
+	// Duplicate hashes should give the same proof as Prove.
+	dupHashes := []Hash{leaves[0].Hash, leaves[9].Hash, leaves[0].Hash, leaves[5].Hash}
+	dupProof, err := p.ProveWithFetcher(dupHashes, fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := full.Prove(dupHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dupProof, expected) {
+		t.Fatalf("TestProveWithFetcher fail. Expected proof %s, got %s",
+			expected.String(), dupProof.String())
+	}
+}
+
+func TestProofCacheKey(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 15, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofA, err := p.Prove([]Hash{leaves[1].Hash, leaves[6].Hash, leaves[12].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofB, err := p.Prove([]Hash{leaves[12].Hash, leaves[1].Hash, leaves[6].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proofA.Equal(&proofB) {
+		t.Fatalf("TestProofCacheKey fail. Expected proofs to be equal.\n%s\n%s",
+			proofA.String(), proofB.String())
+	}
+	if proofA.CacheKey() != proofB.CacheKey() {
+		t.Fatalf("TestProofCacheKey fail. Expected equal proofs to have the same key")
+	}
+
+	proofC, err := p.Prove([]Hash{leaves[1].Hash, leaves[6].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proofA.Equal(&proofC) {
+		t.Fatalf("TestProofCacheKey fail. Expected proofs to not be equal")
+	}
+	if proofA.CacheKey() == proofC.CacheKey() {
+		t.Fatalf("TestProofCacheKey fail. Expected different proofs to have " +
+			"different keys")
+	}
+}
+
+func TestVerifyPairing(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 15, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delHashes := []Hash{leaves[9].Hash, leaves[2].Hash, leaves[13].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reordering only the delHashes should fail with a pairing error.
+	swapped := []Hash{delHashes[1], delHashes[0], delHashes[2]}
+	err = p.Verify(swapped, proof)
+	if err == nil {
+		t.Fatalf("TestVerifyPairing fail. Expected mismatched pairing to fail")
+	}
+	if !strings.Contains(err.Error(), "paired with target") {
+		t.Fatalf("TestVerifyPairing fail. Expected a pairing error, got: %v", err)
+	}
+
+	// Sorting both with SortWith should keep the pairing.
+	sorted := proof.SortWith(delHashes)
+	if !sort.SliceIsSorted(proof.Targets, func(a, b int) bool {
+		return proof.Targets[a] < proof.Targets[b]
+	}) {
+		t.Fatalf("TestVerifyPairing fail. Targets %v not sorted", proof.Targets)
+	}
+	expected := []Hash{leaves[2].Hash, leaves[9].Hash, leaves[13].Hash}
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Fatalf("TestVerifyPairing fail. Expected:\n%s\ngot:\n%s",
+			printHashes(expected), printHashes(sorted))
+	}
+	err = p.Verify(sorted, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if proof.SortWith(delHashes[:2]) != nil {
+		t.Fatalf("TestVerifyPairing fail. Expected nil for mismatched lengths")
+	}
+}
+
+func TestReorderProofHashes(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 31, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delHashes := []Hash{leaves[0].Hash, leaves[5].Hash, leaves[17].Hash, leaves[29].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alt := proof.ReorderProofHashes(p.numLeaves, OrderTargetSibling)
+	if reflect.DeepEqual(alt.Proof, proof.Proof) {
+		t.Fatalf("TestReorderProofHashes fail. Expected the order to change")
+	}
+
+	// The first hash for leaf 0 is the sibling of leaf 0.
+	if alt.Proof[0] != leaves[1].Hash {
+		t.Fatalf("TestReorderProofHashes fail. Expected the first proof hash "+
+			"to be the sibling of the first target.\n%s", alt.String())
+	}
+
+	back := alt.ReorderProofHashes(p.numLeaves, OrderPosition)
+	if !back.Equal(&proof) {
+		t.Fatalf("TestReorderProofHashes fail. Expected:\n%s\ngot:\n%s",
+			proof.String(), back.String())
+	}
+	err = p.Verify(delHashes, back)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serialize with the alternate order and read it back.
+	var buf bytes.Buffer
+	_, err = proof.SerializeWithOrder(&buf, p.numLeaves, OrderTargetSibling)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plain Proof
+	err = plain.Deserialize(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plain.Equal(&alt) {
+		t.Fatalf("TestReorderProofHashes fail. Expected:\n%s\ngot:\n%s",
+			alt.String(), plain.String())
+	}
+
+	var read Proof
+	err = read.DeserializeWithOrder(&buf, p.numLeaves, OrderTargetSibling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.Equal(&proof) {
+		t.Fatalf("TestReorderProofHashes fail. Expected:\n%s\ngot:\n%s",
+			proof.String(), read.String())
+	}
+	err = p.Verify(delHashes, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProveSingle(t *testing.T) {
+	t.Parallel()
+
+	// A single leaf has no proof.
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 1, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := p.ProveSingle(leaves[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(proof.Targets, []uint64{0}) || len(proof.Proof) != 0 {
+		t.Fatalf("TestProveSingle fail. Expected a single target of 0 and no "+
+			"proof hashes, got:\n%s", proof.String())
+	}
+
+	// Not found hashes should error out.
+	_, err = p.ProveSingle(Hash{1})
+	if err == nil {
+		t.Fatalf("TestProveSingle fail. Expected an error for a missing hash")
+	}
+
+	sc := newSimChainWithSeed(0x0f, 0)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(9)
+		for _, delHash := range delHashes {
+			expected, err := p.Prove([]Hash{delHash})
+			if err != nil {
+				t.Fatalf("TestProveSingle fail at block %d. Error: %v", b, err)
+			}
+			got, err := p.ProveSingle(delHash)
+			if err != nil {
+				t.Fatalf("TestProveSingle fail at block %d. Error: %v", b, err)
+			}
+			if !reflect.DeepEqual(expected, got) {
+				t.Fatalf("TestProveSingle fail at block %d. Expected:\n%s\ngot:\n%s",
+					b, expected.String(), got.String())
+			}
+			err = p.Verify([]Hash{delHash}, got)
+			if err != nil {
+				t.Fatalf("TestProveSingle fail at block %d. Error: %v", b, err)
+			}
+		}
+
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestProveSingle fail at block %d. Error: %v", b, err)
+		}
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestProveSingle fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func BenchmarkProveSingle(b *testing.B) {
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 1<<16, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	hash := leaves[12345].Hash
+
+	b.Run("Prove", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := p.Prove([]Hash{hash})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ProveSingle", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := p.ProveSingle(hash)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestCompressionSavings(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x0f, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(9)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestCompressionSavings fail at block %d. Error: %v", b, err)
+		}
+
+		fullCount := 0
+		for _, delHash := range delHashes {
+			single, err := p.ProveSingle(delHash)
+			if err != nil {
+				t.Fatalf("TestCompressionSavings fail at block %d. Error: %v", b, err)
+			}
+			fullCount += len(single.Proof)
+		}
+
+		expected := fullCount - len(proof.Proof)
+		got := proof.CompressionSavings(p.numLeaves)
+		if got != expected {
+			t.Fatalf("TestCompressionSavings fail at block %d. Expected %d, got %d",
+				b, expected, got)
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestCompressionSavings fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func TestProofJSON(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 15, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofs := []Proof{{}, {Targets: []uint64{0}}}
+	proof, err := p.Prove([]Hash{leaves[4].Hash, leaves[1].Hash, leaves[11].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofs = append(proofs, proof)
+
+	for _, proof := range proofs {
+		data, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got Proof
+		err = json.Unmarshal(data, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(proof, got) {
+			t.Fatalf("TestProofJSON fail. Expected:\n%s\ngot:\n%s\njson: %s",
+				proof.String(), got.String(), data)
+		}
+	}
+
+	expected := `{"targets":[3],"proof":["` + strings.Repeat("ab", 32) + `"]}`
+	var got Proof
+	err = json.Unmarshal([]byte(expected), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Fatalf("TestProofJSON fail. Expected %s, got %s", expected, data)
+	}
+
+	// Hex strings that aren't 64 characters should be rejected.
+	for _, str := range []string{
+		`{"targets":[3],"proof":["` + strings.Repeat("ab", 31) + `"]}`,
+		`{"targets":[3],"proof":["` + strings.Repeat("ab", 33) + `"]}`,
+		`{"targets":[3],"proof":["` + strings.Repeat("zz", 32) + `"]}`,
+	} {
+		before := got
+		err = json.Unmarshal([]byte(str), &got)
+		if err == nil {
+			t.Fatalf("TestProofJSON fail. Expected an error for %s", str)
+		}
+		if !reflect.DeepEqual(before, got) {
+			t.Fatalf("TestProofJSON fail. Proof modified after an error")
+		}
+	}
+}
+
+// instrumentedHasher counts every parent hash calculated.
+type instrumentedHasher struct {
+	count *int
+}
+
+func (h instrumentedHasher) ParentHash(left, right Hash) Hash {
+	*h.count++
+	return parentHash(left, right)
+}
+
+func TestVerifyWithCost(t *testing.T) {
+	t.Parallel()
+
+	var count int
+	p := NewAccumulatorWithHasher(true, instrumentedHasher{&count})
+
+	sc := newSimChainWithSeed(0x0f, 0)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(9)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestVerifyWithCost fail at block %d. Error: %v", b, err)
+		}
+
+		count = 0
+		err = p.Verify(delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestVerifyWithCost fail at block %d. Error: %v", b, err)
+		}
+		expected := count
+
+		count = 0
+		got, err := p.VerifyWithCost(delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestVerifyWithCost fail at block %d. Error: %v", b, err)
+		}
+		if got != expected || count != expected {
+			t.Fatalf("TestVerifyWithCost fail at block %d. Expected cost of %d, "+
+				"got %d with %d hashes counted", b, expected, got, count)
+		}
+
+		// Every calculated node should've taken one hash.
+		if len(delHashes) > 0 {
+			positions, _, err := VerifyAndReturnHashes(
+				Stump{p.GetRoots(), p.numLeaves}, delHashes, proof)
+			if err != nil {
+				t.Fatalf("TestVerifyWithCost fail at block %d. Error: %v", b, err)
+			}
+			if got != len(positions) {
+				t.Fatalf("TestVerifyWithCost fail at block %d. Calculated %d "+
+					"nodes but got a cost of %d", b, len(positions), got)
+			}
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestVerifyWithCost fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func TestProveStable(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 27, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forestRows := treeRows(p.numLeaves)
+
+	// Leaf 5 is under the root at row 4.
+	hash := leaves[5].Hash
+	proof, err := p.ProveStable(hash, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Proof) != 2 {
+		t.Fatalf("TestProveStable fail. Expected 2 proof hashes below the "+
+			"stable row, got %d", len(proof.Proof))
+	}
+	stable, err := p.StableProofHashes(hash, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stable) != 2 {
+		t.Fatalf("TestProveStable fail. Expected 2 stable hashes, got %d", len(stable))
+	}
+
+	// The stable hashes should be the siblings of the ancestors at and above
+	// the stable row.
+	pos := uint64(5)
+	for row := uint8(0); row < 4; row++ {
+		if row >= 2 && stable[row-2] != p.getHash(sibling(pos)) {
+			t.Fatalf("TestProveStable fail. Expected the hash at row %d to be "+
+				"the hash at position %d", row, sibling(pos))
+		}
+		pos = parent(pos, forestRows)
+	}
+
+	full := AttachStableHashes(proof, stable)
+	err = p.Verify([]Hash{hash}, full)
+	if err != nil {
+		t.Fatalf("TestProveStable fail. Error: %v", err)
+	}
+
+	// Deleting a leaf next to the watched leaf only changes the hashes below
+	// the stable row. The cached stable hashes should still be usable.
+	delHashes := []Hash{leaves[6].Hash}
+	delProof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(nil, delHashes, delProof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err = p.ProveStable(hash, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Verify([]Hash{hash}, AttachStableHashes(proof, stable))
+	if err != nil {
+		t.Fatalf("TestProveStable fail. Cached stable hashes didn't verify. Error: %v", err)
+	}
+
+	_, err = p.ProveStable(hash, 5)
+	if err == nil {
+		t.Fatalf("TestProveStable fail. Expected an error for a stableRow above the root")
+	}
+	_, err = p.StableProofHashes(hash, 5)
+	if err == nil {
+		t.Fatalf("TestProveStable fail. Expected an error for a stableRow above the root")
+	}
+}
+
+func TestProveWithPaths(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x0f, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(9)
+		proof, paths, err := p.ProveWithPaths(delHashes)
+		if err != nil {
+			t.Fatalf("TestProveWithPaths fail at block %d. Error: %v", b, err)
+		}
+		if len(paths) != len(proof.Targets) {
+			t.Fatalf("TestProveWithPaths fail at block %d. Got %d paths for %d targets",
+				b, len(paths), len(proof.Targets))
+		}
+
+		forestRows := treeRows(p.numLeaves)
+		for i, path := range paths {
+			if path[0] != proof.Targets[i] {
+				t.Fatalf("TestProveWithPaths fail at block %d. Path %v doesn't "+
+					"start at target %d", b, path, proof.Targets[i])
+			}
+			for j := 1; j < len(path); j++ {
+				if path[j] != parent(path[j-1], forestRows) {
+					t.Fatalf("TestProveWithPaths fail at block %d. %d isn't the "+
+						"parent of %d in path %v", b, path[j], path[j-1], path)
+				}
+			}
+
+			root, err := getRootPosition(proof.Targets[i], p.numLeaves, forestRows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path[len(path)-1] != root {
+				t.Fatalf("TestProveWithPaths fail at block %d. Path %v doesn't "+
+					"end at root %d", b, path, root)
+			}
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestProveWithPaths fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func TestMergeProofs(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 31, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aHashes := []Hash{leaves[3].Hash, leaves[0].Hash, leaves[17].Hash}
+	bHashes := []Hash{leaves[17].Hash, leaves[2].Hash, leaves[29].Hash, leaves[9].Hash}
+	a, err := p.Prove(aHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Prove(bHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, delHashes, err := MergeProofs(p.numLeaves, a, b, aHashes, bHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHashes := []Hash{leaves[0].Hash, leaves[2].Hash, leaves[3].Hash,
+		leaves[9].Hash, leaves[17].Hash, leaves[29].Hash}
+	expected, err := p.Prove(expectedHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !merged.Equal(&expected) || !reflect.DeepEqual(delHashes, expectedHashes) {
+		t.Fatalf("TestMergeProofs fail. Expected:\n%s\ngot:\n%s",
+			expected.String(), merged.String())
+	}
+	err = p.Verify(delHashes, merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A different hash for the same target should be a conflict.
+	badHashes := []Hash{{1}, leaves[2].Hash, leaves[29].Hash, leaves[9].Hash}
+	_, _, err = MergeProofs(p.numLeaves, a, b, aHashes, badHashes)
+	if err == nil {
+		t.Fatalf("TestMergeProofs fail. Expected an error for conflicting target hashes")
+	}
+
+	// A different proof hash that's also calculated by the other proof should
+	// be a conflict too.
+	badB := Proof{Targets: b.Targets, Proof: make([]Hash, len(b.Proof))}
+	copy(badB.Proof, b.Proof)
+	badB.Proof[0][0] ^= 0xff
+	_, _, err = MergeProofs(p.numLeaves, a, badB, aHashes, bHashes)
+	if err == nil {
+		t.Fatalf("TestMergeProofs fail. Expected an error for conflicting proof hashes")
+	}
+}
+
+func TestCombineProofUpdates(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(0))
+	randomUpdate := func() ProofUpdate {
+		var update ProofUpdate
+		for i := 0; i < rng.Intn(10); i++ {
+			update.Removed = append(update.Removed, uint64(rng.Intn(30)))
+		}
+		for i := 0; i < rng.Intn(10); i++ {
+			update.Positions = append(update.Positions, uint64(rng.Intn(30)))
+			update.Hashes = append(update.Hashes, Hash{uint8(rng.Intn(256))})
+		}
+		return update
+	}
+
+	for i := 0; i < 100; i++ {
+		initial := make(map[uint64]Hash)
+		for j := 0; j < 20; j++ {
+			initial[uint64(rng.Intn(30))] = Hash{uint8(rng.Intn(256))}
+		}
+
+		updates := make([]ProofUpdate, rng.Intn(8))
+		for j := range updates {
+			updates[j] = randomUpdate()
+		}
+
+		expected := make(map[uint64]Hash, len(initial))
+		for pos, hash := range initial {
+			expected[pos] = hash
+		}
+		for _, update := range updates {
+			update.Apply(expected)
+		}
+
+		got := make(map[uint64]Hash, len(initial))
+		for pos, hash := range initial {
+			got[pos] = hash
+		}
+		combined := CombineProofUpdates(updates)
+		combined.Apply(got)
+
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("TestCombineProofUpdates fail. Expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestSubProof(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x0f, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(9)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestSubProof fail at block %d. Error: %v", b, err)
+		}
+
+		// Keep every other target.
+		var keepTargets []uint64
+		var keepHashes []Hash
+		for i := 0; i < len(proof.Targets); i += 2 {
+			keepTargets = append(keepTargets, proof.Targets[i])
+			keepHashes = append(keepHashes, delHashes[i])
+		}
+
+		sub, subHashes, err := proof.SubProof(p.numLeaves, delHashes, keepTargets)
+		if err != nil {
+			t.Fatalf("TestSubProof fail at block %d. Error: %v", b, err)
+		}
+		if len(keepTargets) > 0 {
+			expected, err := p.Prove(keepHashes)
+			if err != nil {
+				t.Fatalf("TestSubProof fail at block %d. Error: %v", b, err)
+			}
+			if !expected.Equal(&sub) {
+				t.Fatalf("TestSubProof fail at block %d. Expected:\n%s\ngot:\n%s",
+					b, expected.String(), sub.String())
+			}
+		}
+		_, err = StumpVerify(p.ToStump(), subHashes, sub)
+		if err != nil {
+			t.Fatalf("TestSubProof fail at block %d. Error: %v", b, err)
+		}
+
+		if len(proof.Targets) > 0 {
+			_, _, err = proof.SubProof(p.numLeaves, delHashes, []uint64{p.numLeaves})
+			if err == nil {
+				t.Fatalf("TestSubProof fail at block %d. Expected an error for "+
+					"a target not in the proof", b)
+			}
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestSubProof fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func TestLeafData(t *testing.T) {
+	t.Parallel()
+
+	leaves := make([]LeafData, 9)
+	for i := range leaves {
+		leaves[i].Hash = Hash{uint8(i + 1)}
+		if i%2 == 0 {
+			leaves[i].Metadata = []byte{uint8(i), 0xaa, 0xbb}
+		}
+	}
+
+	// The default mode shouldn't accept leaf data.
+	p := NewAccumulator(true)
+	err := p.AddLeafData(leaves, false)
+	if err == nil {
+		t.Fatalf("TestLeafData fail. Expected AddLeafData to fail in LeafModeHash")
+	}
+	_, err = p.ProveLeafData([]Hash{leaves[0].LeafHash()})
+	if err == nil {
+		t.Fatalf("TestLeafData fail. Expected ProveLeafData to fail in LeafModeHash")
+	}
+
+	p.SetLeafMode(LeafModeMetadata)
+	err = p.AddLeafData(leaves, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leaves without metadata should be added as just their hash.
+	if leaves[1].LeafHash() != leaves[1].Hash {
+		t.Fatalf("TestLeafData fail. Expected a leaf without metadata to be unchanged")
+	}
+
+	// The metadata should change what gets committed to.
+	noMeta := NewAccumulator(true)
+	noMeta.SetLeafMode(LeafModeMetadata)
+	plain := make([]LeafData, len(leaves))
+	for i, leaf := range leaves {
+		plain[i] = LeafData{Hash: leaf.Hash}
+	}
+	err = noMeta.AddLeafData(plain, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(p.GetRoots(), noMeta.GetRoots()) {
+		t.Fatalf("TestLeafData fail. Expected the metadata to change the roots")
+	}
+
+	proven := []Hash{leaves[0].LeafHash(), leaves[3].LeafHash(), leaves[6].LeafHash()}
+	proof, err := p.ProveLeafData(proven)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []LeafData{leaves[0], leaves[3], leaves[6]}
+	if !reflect.DeepEqual(proof.Leaves, expected) {
+		t.Fatalf("TestLeafData fail. Expected leaves %v, got %v", expected, proof.Leaves)
+	}
+	err = p.VerifyLeafData(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump := Stump{Roots: p.GetRoots(), NumLeaves: p.numLeaves}
+	err = VerifyLeafDataProof(stump, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Different metadata shouldn't verify.
+	tampered := proof
+	tampered.Leaves = []LeafData{leaves[0], leaves[3], {leaves[6].Hash, []byte{6, 0xaa, 0xbc}}}
+	err = p.VerifyLeafData(tampered)
+	if err == nil {
+		t.Fatalf("TestLeafData fail. Expected tampered metadata to fail verification")
+	}
+	tampered.Leaves[2] = LeafData{Hash: leaves[6].Hash}
+	err = VerifyLeafDataProof(stump, tampered)
+	if err == nil {
+		t.Fatalf("TestLeafData fail. Expected missing metadata to fail verification")
+	}
+
+	// Undoing a deletion should bring back the metadata of the deleted leaf.
+	undo, err := p.ModifyWithUndo(nil, []Hash{leaves[4].LeafHash()}, []uint64{4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.ProveLeafData([]Hash{leaves[4].LeafHash()})
+	if err == nil {
+		t.Fatalf("TestLeafData fail. Expected ProveLeafData to fail for a deleted leaf")
+	}
+	err = p.Undo(0, undo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	undoneProof, err := p.ProveLeafData([]Hash{leaves[4].LeafHash()})
+	if err != nil {
+		t.Fatalf("TestLeafData fail. Error: %v", err)
+	}
+	if !reflect.DeepEqual(undoneProof.Leaves, []LeafData{leaves[4]}) {
+		t.Fatalf("TestLeafData fail. Expected leaves %v after undo, got %v",
+			[]LeafData{leaves[4]}, undoneProof.Leaves)
+	}
+	err = p.VerifyLeafData(undoneProof)
+	if err != nil {
+		t.Fatalf("TestLeafData fail. Error: %v", err)
+	}
+
+	// The metadata of deleted leaves shouldn't be kept.
+	err = p.Modify(nil, []Hash{leaves[6].LeafHash()}, []uint64{6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := p.leafData[leaves[6].LeafHash().mini()]; found {
+		t.Fatalf("TestLeafData fail. Expected the metadata of a deleted leaf to be removed")
+	}
+	_, err = p.ProveLeafData([]Hash{leaves[6].LeafHash()})
+	if err == nil {
+		t.Fatalf("TestLeafData fail. Expected ProveLeafData to fail for a deleted leaf")
+	}
+}
+
+func TestProofUpdateBetween(t *testing.T) {
+	t.Parallel()
+
+	old := map[uint64]Hash{1: {1}, 2: {2}, 3: {3}}
+	new := map[uint64]Hash{2: {2}, 3: {4}, 5: {5}}
+	update := ProofUpdateBetween(old, new)
+	update.Apply(old)
+	if !reflect.DeepEqual(old, new) {
+		t.Fatalf("TestProofUpdateBetween fail. Expected %v, got %v", new, old)
+	}
+	if update.SerializeSize() != 2*40+8 {
+		t.Fatalf("TestProofUpdateBetween fail. Expected size %d, got %d",
+			2*40+8, update.SerializeSize())
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 15, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump := Stump{Roots: p.GetRoots(), NumLeaves: p.numLeaves}
+
+	_, err = p.Prove([]Hash{{0xff}})
+	if !errors.Is(err, ErrHashNotFound) {
+		t.Fatalf("TestTypedErrors fail. Expected ErrHashNotFound, got: %v", err)
+	}
+
+	delHashes := []Hash{leaves[2].Hash, leaves[9].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongHashes := []Hash{leaves[2].Hash, leaves[10].Hash}
+	err = p.Verify(wrongHashes, proof)
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("TestTypedErrors fail. Expected ErrRootMismatch, got: %v", err)
+	}
+	_, err = StumpVerify(stump, wrongHashes, proof)
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("TestTypedErrors fail. Expected ErrRootMismatch, got: %v", err)
+	}
+	err = p.ModifyWithProof(nil, wrongHashes, proof)
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("TestTypedErrors fail. Expected ErrRootMismatch, got: %v", err)
+	}
+
+	truncated := Proof{Targets: proof.Targets, Proof: proof.Proof[:len(proof.Proof)-1]}
+	err = p.Verify(delHashes, truncated)
+	if !errors.Is(err, ErrProofMalformed) {
+		t.Fatalf("TestTypedErrors fail. Expected ErrProofMalformed, got: %v", err)
+	}
+	_, err = StumpVerify(stump, delHashes, truncated)
+	if !errors.Is(err, ErrProofMalformed) {
+		t.Fatalf("TestTypedErrors fail. Expected ErrProofMalformed, got: %v", err)
+	}
+	err = VerifyStream(stump, delHashes, truncated, nil)
+	if !errors.Is(err, ErrProofMalformed) {
+		t.Fatalf("TestTypedErrors fail. Expected ErrProofMalformed, got: %v", err)
+	}
+	err = p.Verify(delHashes[:1], proof)
+	if !errors.Is(err, ErrProofMalformed) {
+		t.Fatalf("TestTypedErrors fail. Expected ErrProofMalformed, got: %v", err)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 31, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := p.Prove([]Hash{leaves[3].Hash, leaves[17].Hash, leaves[8].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Prove([]Hash{leaves[17].Hash, leaves[8].Hash, leaves[3].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(a.Targets, b.Targets) {
+		t.Fatalf("TestCanonicalize fail. Expected the targets to be in different orders")
+	}
+
+	var aBuf, bBuf bytes.Buffer
+	canonA, canonB := a.Canonicalize(), b.Canonicalize()
+	_, err = canonA.Serialize(&aBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = canonB.Serialize(&bBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(aBuf.Bytes(), bBuf.Bytes()) {
+		t.Fatalf("TestCanonicalize fail. Canonical proofs serialized differently:\n%x\n%x",
+			aBuf.Bytes(), bBuf.Bytes())
+	}
+
+	// The canonical proof must still verify with the sorted delHashes.
+	delHashes := a.SortWith([]Hash{leaves[3].Hash, leaves[17].Hash, leaves[8].Hash})
+	err = p.Verify(delHashes, canonA)
+	if err != nil {
+		t.Fatalf("TestCanonicalize fail. Error: %v", err)
+	}
+}
+
+func TestIngest(t *testing.T) {
+	t.Parallel()
+
+	full := NewAccumulator(true)
+	pruned := NewAccumulator(false)
+	leaves, _, _ := getAddsAndDels(uint32(full.numLeaves), 27, 0)
+	err := full.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prunedLeaves := make([]Leaf, len(leaves))
+	for i := range leaves {
+		prunedLeaves[i] = Leaf{Hash: leaves[i].Hash}
+	}
+	err = pruned.Modify(prunedLeaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delHashes := []Hash{leaves[4].Hash, leaves[13].Hash, leaves[26].Hash}
+	_, err = pruned.Prove(delHashes)
+	if err == nil {
+		t.Fatalf("TestIngest fail. Expected the pruned pollard to not be able to prove")
+	}
+
+	proof, err := full.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pruned.Ingest(delHashes, proof)
+	if err != nil {
+		t.Fatalf("TestIngest fail. Error: %v", err)
+	}
+	err = pruned.posMapSanity()
+	if err != nil {
+		t.Fatalf("TestIngest fail. Error: %v", err)
+	}
+
+	got, err := pruned.Prove(delHashes)
+	if err != nil {
+		t.Fatalf("TestIngest fail. Error: %v", err)
+	}
+	if !got.Equal(&proof) {
+		t.Fatalf("TestIngest fail. Expected proof:\n%s\ngot:\n%s",
+			proof.String(), got.String())
+	}
+	if !reflect.DeepEqual(pruned.GetRoots(), full.GetRoots()) {
+		t.Fatalf("TestIngest fail. Roots changed after ingesting")
+	}
+
+	// Ingesting an invalid proof shouldn't cache anything.
+	wrongHashes := []Hash{leaves[5].Hash, leaves[13].Hash, leaves[26].Hash}
+	err = pruned.Ingest(wrongHashes, proof)
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("TestIngest fail. Expected ErrRootMismatch, got: %v", err)
+	}
+	if pruned.HasLeaf(leaves[5].Hash) {
+		t.Fatalf("TestIngest fail. Cached a leaf from an invalid proof")
+	}
+}
+
+func TestIngestWithHasher(t *testing.T) {
+	t.Parallel()
+
+	full := NewAccumulatorWithHasher(true, sha256Hasher{})
+	pruned := NewAccumulatorWithHasher(false, sha256Hasher{})
+	leaves, _, _ := getAddsAndDels(uint32(full.numLeaves), 27, 0)
+	err := full.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prunedLeaves := make([]Leaf, len(leaves))
+	for i := range leaves {
+		prunedLeaves[i] = Leaf{Hash: leaves[i].Hash}
+	}
+	err = pruned.Modify(prunedLeaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delHashes := []Hash{leaves[0].Hash, leaves[13].Hash, leaves[26].Hash}
+	proof, err := full.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pruned.Ingest(delHashes, proof)
+	if err != nil {
+		t.Fatalf("TestIngestWithHasher fail. Error: %v", err)
+	}
+	err = pruned.checkHashes()
+	if err != nil {
+		t.Fatalf("TestIngestWithHasher fail. Error: %v", err)
+	}
+
+	got, err := pruned.Prove(delHashes)
+	if err != nil {
+		t.Fatalf("TestIngestWithHasher fail. Error: %v", err)
+	}
+	if !got.Equal(&proof) {
+		t.Fatalf("TestIngestWithHasher fail. Expected proof:\n%s\ngot:\n%s",
+			proof.String(), got.String())
+	}
+}
+
+func TestProveTransition(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 20, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump := p.ToStump()
+
+	targets := []uint64{3, 11, 19}
+	adds, _, _ := getAddsAndDels(uint32(p.numLeaves), 5, 0)
+	addHashes := make([]Hash, len(adds))
+	for i := range adds {
+		addHashes[i] = adds[i].Hash
+	}
+
+	proof, err := p.ProveTransition(targets, addHashes)
+	if err != nil {
+		t.Fatalf("TestProveTransition fail. Error: %v", err)
+	}
+	delHashes := make([]Hash, len(proof.Targets))
+	for i, target := range proof.Targets {
+		delHashes[i] = p.getHash(target)
+	}
+
+	_, err = stump.Update(delHashes, addHashes, proof)
+	if err != nil {
+		t.Fatalf("TestProveTransition fail. Error: %v", err)
+	}
+	err = p.Modify(adds, delHashes, proof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stump.Roots, p.GetRoots()) {
+		t.Fatalf("TestProveTransition fail. Expected roots:\n%s\ngot:\n%s",
+			printHashes(p.GetRoots()), printHashes(stump.Roots))
+	}
+
+	_, err = p.ProveTransition([]uint64{3}, addHashes[:1])
+	if err == nil {
+		t.Fatalf("TestProveTransition fail. Expected an error for an add " +
+			"that's already in the accumulator")
+	}
+}
+
+func TestProveKeepsOrder(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 16, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := p.Prove([]Hash{leaves[9].Hash, leaves[1].Hash, leaves[14].Hash, leaves[4].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint64{9, 1, 14, 4}
+	if !reflect.DeepEqual(proof.Targets, expected) {
+		t.Fatalf("TestProveKeepsOrder fail. Expected targets %v, got %v",
+			expected, proof.Targets)
+	}
+}
+
+func TestTargetsForHashes(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 13, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := []Hash{leaves[7].Hash, leaves[0].Hash, leaves[12].Hash}
+	targets, err := p.TargetsForHashes(hashes)
+	if err != nil {
+		t.Fatalf("TestTargetsForHashes fail. Error: %v", err)
+	}
+	proof, err := p.Prove(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, proof.Targets) {
+		t.Fatalf("TestTargetsForHashes fail. Expected %v, got %v", proof.Targets, targets)
+	}
+
+	missing := Hash{0xff}
+	_, err = p.TargetsForHashes([]Hash{leaves[1].Hash, missing})
+	if !errors.Is(err, ErrHashNotFound) {
+		t.Fatalf("TestTargetsForHashes fail. Expected ErrHashNotFound, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), hex.EncodeToString(missing[:])) {
+		t.Fatalf("TestTargetsForHashes fail. Expected the missing hash in the error, got: %v", err)
+	}
+}
+
+func TestProofDedup(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 14, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delHashes := []Hash{leaves[6].Hash, leaves[2].Hash, leaves[6].Hash, leaves[11].Hash, leaves[2].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deduped, dedupedHashes, err := proof.Dedup(delHashes)
+	if err != nil {
+		t.Fatalf("TestProofDedup fail. Error: %v", err)
+	}
+	expectedTargets := []uint64{6, 2, 11}
+	if !reflect.DeepEqual(deduped.Targets, expectedTargets) {
+		t.Fatalf("TestProofDedup fail. Expected targets %v, got %v",
+			expectedTargets, deduped.Targets)
+	}
+	expectedHashes := []Hash{leaves[6].Hash, leaves[2].Hash, leaves[11].Hash}
+	if !reflect.DeepEqual(dedupedHashes, expectedHashes) {
+		t.Fatalf("TestProofDedup fail. Expected hashes:\n%s\ngot:\n%s",
+			printHashes(expectedHashes), printHashes(dedupedHashes))
+	}
+	err = p.Verify(dedupedHashes, deduped)
+	if err != nil {
+		t.Fatalf("TestProofDedup fail. Error: %v", err)
+	}
+
+	// The same target paired with a different hash is inconsistent.
+	badHashes := []Hash{leaves[6].Hash, leaves[2].Hash, leaves[7].Hash, leaves[11].Hash, leaves[2].Hash}
+	_, _, err = proof.Dedup(badHashes)
+	if !errors.Is(err, ErrProofMalformed) {
+		t.Fatalf("TestProofDedup fail. Expected ErrProofMalformed, got: %v", err)
+	}
+}
+
+func TestProofSanityCheck(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 11, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := p.Prove([]Hash{leaves[1].Hash, leaves[6].Hash, leaves[10].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = proof.SanityCheck(p.numLeaves)
+	if err != nil {
+		t.Fatalf("TestProofSanityCheck fail. Error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		proof Proof
+	}{
+		{"out of range", Proof{Targets: []uint64{1, 6, 11}, Proof: proof.Proof}},
+		{"out of forest", Proof{Targets: []uint64{1, 6, 40}, Proof: proof.Proof}},
+		{"duplicate", Proof{Targets: []uint64{1, 6, 6}, Proof: proof.Proof}},
+		{"too few hashes", Proof{Targets: proof.Targets, Proof: proof.Proof[1:]}},
+		{"too many hashes", Proof{Targets: proof.Targets, Proof: append(proof.Proof, Hash{1})}},
+	}
+	for _, test := range tests {
+		err = test.proof.SanityCheck(p.numLeaves)
+		if !errors.Is(err, ErrProofMalformed) {
+			t.Fatalf("TestProofSanityCheck fail for %s. Expected ErrProofMalformed, "+
+				"got: %v", test.name, err)
+		}
+	}
+}
+
+func FuzzModifyProofAdd(f *testing.F) {
+	var tests = []struct {
+		startLeaves uint32
+		delCount    uint32
+		addCount    uint32
+		targetCount uint32
+		seed        int64
+	}{
+		{8, 0, 1, 2, 0},
+		{7, 0, 1, 3, 0},
+		{13, 0, 19, 4, 1},
+		{1, 0, 5, 1, 2},
+		{100, 0, 300, 20, 424},
+		{8, 2, 1, 2, 0},
+		{7, 3, 1, 3, 0},
+		{13, 5, 19, 4, 1},
+		{32, 16, 40, 8, 5},
+		{100, 60, 300, 20, 424},
+		{6, 5, 2, 1, 2},
+		{12, 11, 4, 1, 1},
+		{31, 28, 1, 3, 2},
+		{38, 27, 2, 11, 0},
+	}
+	for _, test := range tests {
+		f.Add(test.startLeaves, test.delCount, test.addCount, test.targetCount, test.seed)
+	}
+
+	f.Fuzz(func(t *testing.T, startLeaves, delCount, addCount, targetCount uint32, seed int64) {
+		// Set seed to make sure the test is reproducible.
+		rand.Seed(seed)
+
+		if startLeaves == 0 || targetCount == 0 || delCount+targetCount > startLeaves ||
+			startLeaves > 1000 || addCount > 1000 {
+			return
+		}
+
+		p := NewAccumulator(true)
+		leaves, delHashes, _ := getAddsAndDels(uint32(p.numLeaves), startLeaves, delCount)
+		err := p.Modify(leaves, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Delete some leaves so that there are empty roots and moved up trees.
+		delProof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Modify(nil, delHashes, delProof.Targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Pick the targets from the leaves that are left.
+		deleted := make(map[Hash]struct{}, len(delHashes))
+		for _, hash := range delHashes {
+			deleted[hash] = struct{}{}
+		}
+		var targetHashes []Hash
+		for _, idx := range rand.Perm(len(leaves)) {
+			if uint32(len(targetHashes)) == targetCount {
+				break
+			}
+			if _, found := deleted[leaves[idx].Hash]; !found {
+				targetHashes = append(targetHashes, leaves[idx].Hash)
+			}
+		}
+		stump := p.ToStump()
+
+		proof, err := p.Prove(targetHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		adds, _, _ := getAddsAndDels(startLeaves, addCount, 0)
+		addHashes := make([]Hash, len(adds))
+		for i := range adds {
+			addHashes[i] = adds[i].Hash
+		}
+
+		updated, err := ModifyProofAdd(proof, addHashes, stump)
+		if err != nil {
+			t.Fatalf("FuzzModifyProofAdd fail. Error: %v", err)
+		}
+
+		err = p.Modify(adds, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := p.Prove(targetHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !updated.Equal(&expected) {
+			t.Fatalf("FuzzModifyProofAdd fail. Expected:\n%s\ngot:\n%s",
+				expected.String(), updated.String())
+		}
+		err = p.Verify(targetHashes, updated)
+		if err != nil {
+			t.Fatalf("FuzzModifyProofAdd fail. Error: %v", err)
+		}
+	})
+}
+
+func TestModifyProofAddWithHasher(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulatorWithHasher(true, sha256Hasher{})
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 13, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delHashes := []Hash{leaves[8].Hash, leaves[9].Hash, leaves[10].Hash, leaves[11].Hash}
+	delProof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(nil, delHashes, delProof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetHashes := []Hash{leaves[2].Hash, leaves[12].Hash}
+	proof, err := p.Prove(targetHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adds, _, _ := getAddsAndDels(13, 7, 0)
+	addHashes := make([]Hash, len(adds))
+	for i := range adds {
+		addHashes[i] = adds[i].Hash
+	}
+	updated, err := ModifyProofAddWithHasher(sha256Hasher{}, proof, addHashes, p.ToStump())
+	if err != nil {
+		t.Fatalf("TestModifyProofAddWithHasher fail. Error: %v", err)
+	}
+
+	err = p.Modify(adds, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Verify(targetHashes, updated)
+	if err != nil {
+		t.Fatalf("TestModifyProofAddWithHasher fail. Error: %v", err)
+	}
+}
+
+func TestCalculateRootsTruncated(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, delHashes, _ := getAddsAndDels(uint32(p.numLeaves), 31, 5)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing any one of the hashes should be caught instead of reading
+	// past the end of the proof hashes.
+	var scratch VerifyScratch
+	for i := range proof.Proof {
+		truncated := Proof{Targets: proof.Targets, Proof: make([]Hash, 0, len(proof.Proof)-1)}
+		truncated.Proof = append(truncated.Proof, proof.Proof[:i]...)
+		truncated.Proof = append(truncated.Proof, proof.Proof[i+1:]...)
+
+		_, err = calculateRoots(p.numLeaves, delHashes, truncated)
+		if !errors.Is(err, ErrProofMalformed) {
+			t.Fatalf("TestCalculateRootsTruncated fail. Expected ErrProofMalformed, got: %v", err)
+		}
+		if !strings.Contains(err.Error(), "too few hashes") {
+			t.Fatalf("TestCalculateRootsTruncated fail. Unexpected error: %v", err)
+		}
+		_, _, err = calculateHashes(context.Background(), DefaultHasher{},
+			p.numLeaves, treeRows(p.numLeaves), delHashes, truncated, false, &scratch)
+		if !errors.Is(err, ErrProofMalformed) {
+			t.Fatalf("TestCalculateRootsTruncated fail. Expected ErrProofMalformed, got: %v", err)
+		}
+	}
+}
+
+func TestProofHex(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, delHashes, _ := getAddsAndDels(uint32(p.numLeaves), 17, 4)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = proof.Serialize(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := proof.ToHex()
+	if s != hex.EncodeToString(buf.Bytes()) {
+		t.Fatalf("TestProofHex fail. Hex doesn't match the serialized proof")
+	}
+
+	got, err := ProofFromHex(s)
+	if err != nil {
+		t.Fatalf("TestProofHex fail. Error: %v", err)
+	}
+	if !reflect.DeepEqual(got, proof) {
+		t.Fatalf("TestProofHex fail. Expected:\n%s\ngot:\n%s", proof.String(), got.String())
+	}
+
+	for _, bad := range []string{s[:len(s)-1], "zz" + s[2:], s[:len(s)-2], s + "00"} {
+		_, err = ProofFromHex(bad)
+		if err == nil {
+			t.Fatalf("TestProofHex fail. Expected an error for %s", bad)
+		}
+	}
+}
+
+func TestAnswerProofRequest(t *testing.T) {
+	t.Parallel()
+
+	server := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(server.numLeaves), 30, 0)
+	err := server.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		have []int
+		want []int
+	}{
+		{[]int{0}, []int{1}},
+		{[]int{0}, []int{2, 7}},
+		{[]int{0, 5, 8}, []int{3, 9, 14, 29}},
+		{[]int{28}, []int{29}},
+		{[]int{4, 11}, []int{20, 10}},
+	}
+
+	for _, test := range tests {
+		haveHashes := make([]Hash, len(test.have))
+		for i, idx := range test.have {
+			haveHashes[i] = leaves[idx].Hash
+		}
+		wantHashes := make([]Hash, len(test.want))
+		for i, idx := range test.want {
+			wantHashes[i] = leaves[idx].Hash
+		}
+		haveProof, err := server.Prove(haveHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantTargets, err := server.TargetsForHashes(wantHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The client asks for only the positions it's missing.
+		positions := make([]uint64, len(wantTargets))
+		copy(positions, wantTargets)
+		req := ProofRequest{Positions: GetMissingPositions(server.numLeaves, haveProof, positions)}
+
+		answer, err := server.AnswerProofRequest(req)
+		if err != nil {
+			t.Fatalf("TestAnswerProofRequest fail. Error: %v", err)
+		}
+
+		proof, err := MergeProofResponse(haveProof, wantTargets, answer, server.numLeaves)
+		if err != nil {
+			t.Fatalf("TestAnswerProofRequest fail. Error: %v", err)
+		}
+		delHashes := append(haveHashes, wantHashes...)
+		err = server.Verify(delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestAnswerProofRequest fail. Error: %v", err)
+		}
+
+		expected, err := server.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proof.Equal(&expected) {
+			t.Fatalf("TestAnswerProofRequest fail. Expected proof:\n%s\ngot:\n%s",
+				expected.String(), proof.String())
+		}
+
+		// Leaving out a hash should fail.
+		if len(answer) > 0 {
+			_, err = MergeProofResponse(haveProof, wantTargets, answer[1:], server.numLeaves)
+			if !errors.Is(err, ErrProofMalformed) {
+				t.Fatalf("TestAnswerProofRequest fail. Expected %v, got %v",
+					ErrProofMalformed, err)
+			}
+		}
+
+		// An original proof with a hash missing should fail.
+		if len(haveProof.Proof) > 0 {
+			short := Proof{Targets: haveProof.Targets, Proof: haveProof.Proof[1:]}
+			_, err = MergeProofResponse(short, wantTargets, answer, server.numLeaves)
+			if !errors.Is(err, ErrProofMalformed) {
+				t.Fatalf("TestAnswerProofRequest fail. Expected %v for a short "+
+					"original proof, got %v", ErrProofMalformed, err)
+			}
+		}
+	}
+
+	// A pollard that doesn't have the hash shouldn't answer.
+	sparse := NewAccumulator(false)
+	err = sparse.Modify([]Leaf{{Hash: leaves[0].Hash}, {Hash: leaves[1].Hash},
+		{Hash: leaves[2].Hash}, {Hash: leaves[3].Hash}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sparse.AnswerProofRequest(ProofRequest{Positions: []uint64{1}})
+	if !errors.Is(err, ErrHashNotFound) {
+		t.Fatalf("TestAnswerProofRequest fail. Expected %v, got %v", ErrHashNotFound, err)
+	}
+	_, err = server.AnswerProofRequest(ProofRequest{Positions: []uint64{1 << 20}})
+	if err == nil {
+		t.Fatalf("TestAnswerProofRequest fail. Expected an error for an out of range position")
+	}
+}
+
+func TestProofCache(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 1<<14, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := make([]Hash, 0, len(leaves)/4)
+	for i := 0; i < len(leaves); i += 4 {
+		hashes = append(hashes, leaves[i].Hash)
+	}
+
+	// Time the proving without the cache.
+	var uncached time.Duration
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		_, err = p.Prove(hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); i == 0 || elapsed < uncached {
+			uncached = elapsed
+		}
+	}
+
+	cache := NewProofCache()
+	p.SetProofCache(cache)
+	expected, err := p.Prove(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cached time.Duration
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		got, err := p.Prove(hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); i == 0 || elapsed < cached {
+			cached = elapsed
+		}
+		if !got.Equal(&expected) {
+			t.Fatalf("TestProofCache fail. Expected proof:\n%s\ngot:\n%s",
+				expected.String(), got.String())
+		}
+	}
+	if cached >= uncached {
+		t.Fatalf("TestProofCache fail. Cached prove took %v but uncached took %v",
+			cached, uncached)
+	}
+
+	// The cache should be used for any order of the same targets and keep the
+	// targets in the order of the hashes.
+	reversed := make([]Hash, len(hashes))
+	for i := range hashes {
+		reversed[i] = hashes[len(hashes)-1-i]
+	}
+	targets, err := p.TargetsForHashes(reversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found := cache.Get(targets)
+	if !found {
+		t.Fatalf("TestProofCache fail. Expected the reversed targets to be cached")
+	}
+	got, err := p.Prove(reversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Targets, targets) {
+		t.Fatalf("TestProofCache fail. Targets aren't in the order of the hashes")
+	}
+	err = p.Verify(reversed, got)
+	if err != nil {
+		t.Fatalf("TestProofCache fail. Error: %v", err)
+	}
+
+	// Modifying the accumulator should invalidate the cache.
+	adds, _, _ := getAddsAndDels(uint32(p.numLeaves), 1, 0)
+	err = p.Modify(adds, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Prove(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Verify(hashes, got)
+	if err != nil {
+		t.Fatalf("TestProofCache fail. Got a stale proof after Modify. Error: %v", err)
+	}
+
+	// Deletions don't change numLeaves but should still invalidate the cache.
+	delHashes := []Hash{leaves[1].Hash}
+	delProof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(nil, delHashes, delProof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Prove(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Verify(hashes, got)
+	if err != nil {
+		t.Fatalf("TestProofCache fail. Got a stale proof after a deletion. Error: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+// Package simchain simulates a chain of blocks that add and delete leaves from a
+// utreexo accumulator. It's meant to be used for testing the accumulator and code
+// built on top of it. The blocks are deterministic for the same seed.
+package simchain
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/utreexo/utreexo"
+)
+
+// SimChain spits out "blocks" of adds and deletes. Every added leaf is deleted after
+// a random number of blocks.
+type SimChain struct {
+	ttlSlices    [][]utreexo.Hash
+	blockHeight  int32
+	leafCounter  uint64
+	durationMask uint32
+	rnd          *rand.Rand
+}
+
+// NewSimChain initializes and returns a SimChain. The durations of the leaves are
+// masked with duration so duration should be one less than a power of two. The
+// blocks are the same for the same duration and seed.
+func NewSimChain(duration uint32, seed int64) *SimChain {
+	var s SimChain
+	s.blockHeight = -1
+	s.durationMask = duration
+	s.ttlSlices = make([][]utreexo.Hash, s.durationMask+1)
+	s.rnd = rand.New(rand.NewSource(seed))
+	return &s
+}
+
+// NextBlock outputs a new simulation block with numAdds additions. The durations
+// are how many blocks each of the adds lives for. A duration of 0 means that the
+// leaf is never deleted. The delHashes are the hashes of the leaves that are
+// deleted in the block.
+func (s *SimChain) NextBlock(numAdds uint32) (adds []utreexo.Leaf, durations []uint32, delHashes []utreexo.Hash) {
+	s.blockHeight++
+
+	if s.blockHeight == 0 && numAdds == 0 {
+		numAdds = 1
+	}
+	adds = make([]utreexo.Leaf, numAdds)
+	durations = make([]uint32, numAdds)
+
+	// The deletions are preset by the ttlSlices.
+	delHashes = s.ttlSlices[0]
+	s.ttlSlices = append(s.ttlSlices[1:], []utreexo.Hash{})
+
+	// Make a bunch of unique adds and an expiry time for each of them.
+	for j := range adds {
+		adds[j].Hash[0] = uint8(s.leafCounter)
+		adds[j].Hash[1] = uint8(s.leafCounter >> 8)
+		adds[j].Hash[2] = uint8(s.leafCounter >> 16)
+		adds[j].Hash[3] = 0xff
+		adds[j].Hash[4] = uint8(s.leafCounter >> 24)
+		adds[j].Hash[5] = uint8(s.leafCounter >> 32)
+
+		durations[j] = s.rnd.Uint32() & s.durationMask
+
+		// The leaves in the first block live forever. This prevents the
+		// accumulator from going down to 0 leaves.
+		if s.blockHeight == 0 {
+			durations[j] = 0
+		}
+
+		if durations[j] != 0 {
+			s.ttlSlices[durations[j]-1] =
+				append(s.ttlSlices[durations[j]-1], adds[j].Hash)
+		}
+
+		s.leafCounter++
+	}
+
+	return adds, durations, delHashes
+}
+
+// EstimateUpdateBandwidth simulates a chain of numBlocks blocks and returns the
+// average bytes per block needed to keep the proof for a watch set of watchSize
+// leaves up to date. The watched leaves are picked at random from the leaves of the
+// first block as those are never deleted. Every following block adds addsPerBlock
+// leaves. The estimate is the same for the same seed.
+func EstimateUpdateBandwidth(watchSize int, numBlocks int, addsPerBlock uint32, seed int64) (int, error) {
+	if watchSize < 0 {
+		return 0, fmt.Errorf("EstimateUpdateBandwidth fail. Negative watchSize %d",
+			watchSize)
+	}
+	if numBlocks <= 0 {
+		return 0, fmt.Errorf("EstimateUpdateBandwidth fail. Need at least one "+
+			"block, got %d", numBlocks)
+	}
+
+	sc := NewSimChain(0x1f, seed)
+	rnd := rand.New(rand.NewSource(seed))
+	p := utreexo.NewAccumulator(true)
+
+	// Make the first block large enough so that the watched leaves are spread out.
+	initialCount := 4 * watchSize
+	if initialCount < int(addsPerBlock) {
+		initialCount = int(addsPerBlock)
+	}
+	initial, _, _ := sc.NextBlock(uint32(initialCount))
+	watched := make([]utreexo.Hash, 0, watchSize)
+	for _, idx := range rnd.Perm(len(initial))[:watchSize] {
+		watched = append(watched, initial[idx].Hash)
+	}
+	err := p.Modify(initial, nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("EstimateUpdateBandwidth fail. Error: %v", err)
+	}
+
+	// cached returns the proof hashes for the watched leaves keyed by their
+	// positions.
+	cached := func() (map[uint64]utreexo.Hash, error) {
+		proof, err := p.Prove(watched)
+		if err != nil {
+			return nil, err
+		}
+		positions, _ := utreexo.ProofPositions(proof.Targets,
+			p.NumLeaves(), utreexo.TreeRows(p.NumLeaves()))
+		cache := make(map[uint64]utreexo.Hash, len(positions))
+		for i, pos := range positions {
+			cache[pos] = proof.Proof[i]
+		}
+		return cache, nil
+	}
+	cache, err := cached()
+	if err != nil {
+		return 0, fmt.Errorf("EstimateUpdateBandwidth fail. Error: %v", err)
+	}
+
+	total := 0
+	for b := 0; b < numBlocks; b++ {
+		adds, _, delHashes := sc.NextBlock(addsPerBlock)
+
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			return 0, fmt.Errorf("EstimateUpdateBandwidth fail at block %d. "+
+				"Error: %v", b, err)
+		}
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			return 0, fmt.Errorf("EstimateUpdateBandwidth fail at block %d. "+
+				"Error: %v", b, err)
+		}
+
+		newCache, err := cached()
+		if err != nil {
+			return 0, fmt.Errorf("EstimateUpdateBandwidth fail at block %d. "+
+				"Error: %v", b, err)
+		}
+		update := utreexo.ProofUpdateBetween(cache, newCache)
+		total += update.SerializeSize()
+		cache = newCache
+	}
+
+	return total / numBlocks, nil
+}
@@ -0,0 +1,101 @@
+package simchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/utreexo/utreexo"
+)
+
+func TestNextBlockDeterministic(t *testing.T) {
+	t.Parallel()
+
+	a := NewSimChain(0x07, 42)
+	b := NewSimChain(0x07, 42)
+	for height := 0; height < 50; height++ {
+		aAdds, aDurations, aDels := a.NextBlock(5)
+		bAdds, bDurations, bDels := b.NextBlock(5)
+		if !reflect.DeepEqual(aAdds, bAdds) ||
+			!reflect.DeepEqual(aDurations, bDurations) ||
+			!reflect.DeepEqual(aDels, bDels) {
+			t.Fatalf("TestNextBlockDeterministic fail at height %d. "+
+				"Same seed gave different blocks", height)
+		}
+	}
+}
+
+func TestSimChainAccumulator(t *testing.T) {
+	t.Parallel()
+
+	sc := NewSimChain(0x07, 0)
+	p := utreexo.NewAccumulator(true)
+	stump := utreexo.Stump{}
+	for height := 0; height < 100; height++ {
+		adds, _, delHashes := sc.NextBlock(5)
+
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestSimChainAccumulator fail at height %d. Error: %v", height, err)
+		}
+
+		addHashes := make([]utreexo.Hash, len(adds))
+		for i := range adds {
+			addHashes[i] = adds[i].Hash
+		}
+		_, err = stump.Update(delHashes, addHashes, proof)
+		if err != nil {
+			t.Fatalf("TestSimChainAccumulator fail at height %d. Error: %v", height, err)
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestSimChainAccumulator fail at height %d. Error: %v", height, err)
+		}
+
+		if !reflect.DeepEqual(stump.Roots, p.GetRoots()) {
+			t.Fatalf("TestSimChainAccumulator fail at height %d. Stump and "+
+				"pollard roots differ", height)
+		}
+	}
+}
+
+func TestEstimateUpdateBandwidth(t *testing.T) {
+	t.Parallel()
+
+	prev := 0
+	for _, watchSize := range []int{1, 10, 50} {
+		estimate, err := EstimateUpdateBandwidth(watchSize, 30, 100, 0)
+		if err != nil {
+			t.Fatalf("TestEstimateUpdateBandwidth fail. Error: %v", err)
+		}
+		if estimate <= prev {
+			t.Fatalf("TestEstimateUpdateBandwidth fail. Expected the estimate "+
+				"for %d watched leaves to be more than %d, got %d",
+				watchSize, prev, estimate)
+		}
+		prev = estimate
+	}
+
+	a, err := EstimateUpdateBandwidth(10, 30, 100, 0)
+	if err != nil {
+		t.Fatalf("TestEstimateUpdateBandwidth fail. Error: %v", err)
+	}
+	b, err := EstimateUpdateBandwidth(10, 30, 100, 0)
+	if err != nil {
+		t.Fatalf("TestEstimateUpdateBandwidth fail. Error: %v", err)
+	}
+	if a != b {
+		t.Fatalf("TestEstimateUpdateBandwidth fail. Expected the same estimate " +
+			"for the same seed")
+	}
+
+	_, err = EstimateUpdateBandwidth(-1, 30, 100, 0)
+	if err == nil {
+		t.Fatalf("TestEstimateUpdateBandwidth fail. Expected an error for a " +
+			"negative watchSize")
+	}
+	_, err = EstimateUpdateBandwidth(10, 0, 100, 0)
+	if err == nil {
+		t.Fatalf("TestEstimateUpdateBandwidth fail. Expected an error for zero blocks")
+	}
+}
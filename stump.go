@@ -1,6 +1,15 @@
 package utreexo
 
-import "fmt"
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
 
 // Stump is bare-minimum data required to validate and update changes in the accumulator.
 // Stump is client-side only and cannot generate proofs on its own. It can only validate
@@ -12,45 +21,573 @@ type Stump struct {
 	NumLeaves uint64
 }
 
+// ChainUpdate is the modification that a single block makes to the accumulator.
+type ChainUpdate struct {
+	// Adds are the hashes of the leaves added in the block.
+	Adds []Hash
+
+	// DelHashes are the hashes of the leaves deleted in the block.
+	DelHashes []Hash
+
+	// Proof is the proof for the DelHashes.
+	Proof Proof
+}
+
+// ConsistencyProof proves that an accumulator state evolved from an earlier one.
+// It's made up of the modifications made to the earlier state in order.
+type ConsistencyProof struct {
+	// Updates are the modifications made to the accumulator, oldest first.
+	Updates []ChainUpdate
+}
+
+// IsAncestorOf returns true if applying the updates in the consistency proof to
+// the stump results in the other stump. Every proof in the updates is verified
+// while applying them.
+func (s Stump) IsAncestorOf(other Stump, consistency ConsistencyProof) bool {
+	stump := Stump{make([]Hash, len(s.Roots)), s.NumLeaves}
+	copy(stump.Roots, s.Roots)
+
+	for _, update := range consistency.Updates {
+		_, err := stump.Update(update.DelHashes, update.Adds, update.Proof)
+		if err != nil {
+			return false
+		}
+	}
+
+	if stump.NumLeaves != other.NumLeaves || len(stump.Roots) != len(other.Roots) {
+		return false
+	}
+
+	return slices.Equal(stump.Roots, other.Roots)
+}
+
+// DiffStumps compares two accumulator states. addedRootCount is the number of
+// roots in new that aren't roots in old and changed is true if the numLeaves or
+// any of the roots differ. Empty roots are not counted.
+//
+// DiffStumps only compares the roots and doesn't check that new was created by
+// modifying old. If old and new don't share any subtrees, every root in new is
+// counted as added. This is also the case if new has fewer leaves than old, in
+// which case new can't be a later state of old. Use IsAncestorOf to check that
+// new evolved from old.
+func DiffStumps(old, new Stump) (addedRootCount int, changed bool) {
+	oldRoots := make(map[Hash]struct{}, len(old.Roots))
+	for _, root := range old.Roots {
+		oldRoots[root] = struct{}{}
+	}
+
+	for _, root := range new.Roots {
+		if root == empty {
+			continue
+		}
+		if _, found := oldRoots[root]; !found {
+			addedRootCount++
+		}
+	}
+
+	changed = old.NumLeaves != new.NumLeaves || !slices.Equal(old.Roots, new.Roots)
+	return addedRootCount, changed
+}
+
+// Serialize encodes the stump to the writer and returns the count of bytes
+// written. The encoding is:
+//
+// [8 bytes NumLeaves big-endian][1 byte root count][32 bytes per root]
+//
+// The roots are written in the canonical order returned by CanonicalRootOrder. An
+// error is returned if the count of the roots doesn't match the NumLeaves.
+func (s *Stump) Serialize(w io.Writer) (int, error) {
+	ordered := CanonicalRootOrder(s.Roots, s.NumLeaves)
+	if ordered == nil {
+		return 0, fmt.Errorf("Stump.Serialize fail. Have %d roots but "+
+			"numLeaves of %d should have %d roots",
+			len(s.Roots), s.NumLeaves, numRoots(s.NumLeaves))
+	}
+
+	var buf [8]byte
+	totalBytes := 0
+
+	binary.BigEndian.PutUint64(buf[:], s.NumLeaves)
+	n, err := w.Write(buf[:])
+	totalBytes += n
+	if err != nil {
+		return totalBytes, err
+	}
+
+	n, err = w.Write([]byte{uint8(len(s.Roots))})
+	totalBytes += n
+	if err != nil {
+		return totalBytes, err
+	}
+
+	for _, root := range ordered {
+		n, err = w.Write(root[:])
+		totalBytes += n
+		if err != nil {
+			return totalBytes, err
+		}
+	}
+
+	return totalBytes, nil
+}
+
+// Deserialize decodes a stump serialized with Serialize from the reader. The roots
+// are read in the canonical order and are put back in the order GetRoots returns
+// them. The stump is only modified if the entire stump was read successfully.
+func (s *Stump) Deserialize(r io.Reader) error {
+	var buf [8]byte
+	_, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		return fmt.Errorf("Stump.Deserialize fail. Couldn't read numLeaves. Error: %v", err)
+	}
+	numLeaves := binary.BigEndian.Uint64(buf[:])
+
+	_, err = io.ReadFull(r, buf[:1])
+	if err != nil {
+		return fmt.Errorf("Stump.Deserialize fail. Couldn't read root count. Error: %v", err)
+	}
+	rootCount := buf[0]
+	if rootCount != numRoots(numLeaves) {
+		return fmt.Errorf("Stump.Deserialize fail. Read %d roots but "+
+			"numLeaves of %d should have %d roots",
+			rootCount, numLeaves, numRoots(numLeaves))
+	}
+
+	var roots []Hash
+	if rootCount > 0 {
+		roots = make([]Hash, rootCount)
+	}
+	for i := range roots {
+		_, err = io.ReadFull(r, roots[i][:])
+		if err != nil {
+			return fmt.Errorf("Stump.Deserialize fail. Couldn't read root %d. Error: %v",
+				i, err)
+		}
+	}
+
+	// The canonical order is by ascending position, which is from the lowest
+	// root to the highest root. Reverse it to get the GetRoots order.
+	for i, j := 0, len(roots)-1; i < j; i, j = i+1, j-1 {
+		roots[i], roots[j] = roots[j], roots[i]
+	}
+
+	s.NumLeaves = numLeaves
+	s.Roots = roots
+
+	return nil
+}
+
 // UpdateStump verifies the proof and returns a new Stump that is updated with
 // additions and the deletions.
 func UpdateStump(delHashes, addHashes []Hash, proof Proof, stump Stump) (Stump, error) {
-	rootCandidates, err := StumpVerify(stump, delHashes, proof)
+	_, err := stump.Update(delHashes, addHashes, proof)
 	if err != nil {
-		return Stump{}, fmt.Errorf("UpdateStump fail: Invalid proof. Error: %s", err)
+		return Stump{}, fmt.Errorf("UpdateStump fail: %w", err)
 	}
 
-	modifiedRoots := stumpDel(stump.NumLeaves, proof)
+	return stump, nil
+}
+
+// Update verifies the proof against the current roots and then updates the
+// stump in place with the deletions and the additions. The returned hashes are
+// the roots that were modified by the deletions, ordered from the lowest root
+// to the highest root.
+//
+// The stump is left untouched if the proof is invalid.
+func (s *Stump) Update(delHashes, addHashes []Hash, proof Proof) ([]Hash, error) {
+	return s.update(DefaultHasher{}, delHashes, addHashes, proof)
+}
+
+// update is Update but hashes with the given hasher.
+func (s *Stump) update(hasher Hasher, delHashes, addHashes []Hash, proof Proof) ([]Hash, error) {
+	rootCandidates, err := stumpVerify(hasher, *s, delHashes, proof)
+	if err != nil {
+		return nil, fmt.Errorf("Stump.Update fail: Invalid proof. Error: %w", err)
+	}
 
-	roots := make([]Hash, len(stump.Roots))
+	if uint64(len(addHashes)) > MaxNumLeaves-s.NumLeaves {
+		return nil, fmt.Errorf("Stump.Update fail. %w. Adding %d leaves to %d leaves",
+			ErrTooManyLeaves, len(addHashes), s.NumLeaves)
+	}
+
+	modifiedRoots := stumpDel(hasher, s.NumLeaves, proof)
+
+	// Copy the roots over to a new slice so that the roots of the stump that
+	// the caller may still be holding on to aren't mutated.
+	roots := make([]Hash, len(s.Roots))
 	idx := 0
-	for i := len(stump.Roots) - 1; i >= 0; i-- {
-		root := stump.Roots[i]
+	for i := len(s.Roots) - 1; i >= 0; i-- {
+		root := s.Roots[i]
 
 		if idx < len(rootCandidates) && root == rootCandidates[idx] {
 			roots[i] = modifiedRoots[idx]
 			idx++
 		} else {
-			roots[i] = stump.Roots[i]
+			roots[i] = s.Roots[i]
+		}
+	}
+
+	*s = stumpAdd(hasher, Stump{roots, s.NumLeaves}, addHashes)
+
+	return modifiedRoots, nil
+}
+
+// Verify verifies the proof against the roots of the stump. It's StumpVerify but
+// only returns the error.
+func (s *Stump) Verify(delHashes []Hash, proof Proof) error {
+	_, err := StumpVerify(*s, delHashes, proof)
+	return err
+}
+
+// GetRoots returns a copy of the roots of the stump.
+func (s *Stump) GetRoots() []Hash {
+	roots := make([]Hash, len(s.Roots))
+	copy(roots, s.Roots)
+	return roots
+}
+
+// Commitment returns a single hash committing to the roots and the numLeaves. It's
+// the SHA512/256 hash of the 8 byte big-endian numLeaves followed by the roots in
+// the canonical order returned by CanonicalRootOrder. The roots passed in are
+// ordered the same way GetRoots returns them. An empty hash is returned if the
+// count of the roots doesn't match the numLeaves.
+func Commitment(roots []Hash, numLeaves uint64) Hash {
+	ordered := CanonicalRootOrder(roots, numLeaves)
+	if ordered == nil {
+		return empty
+	}
+
+	h := sha512.New512_256()
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], numLeaves)
+	h.Write(buf[:])
+	for _, root := range ordered {
+		h.Write(root[:])
+	}
+
+	return *((*Hash)(h.Sum(nil)))
+}
+
+// VerifyAgainstCommitment verifies the proof against the roots after checking that
+// the roots and the numLeaves hash to the commitment. This allows a client to only
+// keep the commitment and have the prover supply the roots.
+func VerifyAgainstCommitment(commitment Hash, numLeaves uint64, roots []Hash,
+	delHashes []Hash, proof Proof) error {
+
+	if len(roots) != int(numRoots(numLeaves)) {
+		return fmt.Errorf("VerifyAgainstCommitment fail. Got %d roots but "+
+			"numLeaves of %d should have %d roots",
+			len(roots), numLeaves, numRoots(numLeaves))
+	}
+	if Commitment(roots, numLeaves) != commitment {
+		return fmt.Errorf("VerifyAgainstCommitment fail. The roots don't " +
+			"match the commitment")
+	}
+
+	_, err := StumpVerify(Stump{Roots: roots, NumLeaves: numLeaves}, delHashes, proof)
+	if err != nil {
+		return fmt.Errorf("VerifyAgainstCommitment fail. Error: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyUpdateUndo is Update but also returns the data needed to undo the update
+// with Stump.Undo. Unlike Update, the verification and the roots after the deletion
+// are calculated in a single walk up the forest. Every node is visited once and
+// hashed once for the verification and at most once for the new roots.
+//
+// The stump is left untouched if the proof is invalid.
+func (s *Stump) VerifyUpdateUndo(delHashes, addHashes []Hash, proof Proof) (UndoData, error) {
+	err := checkProof(s.NumLeaves, treeRows(s.NumLeaves), delHashes, proof)
+	if err != nil {
+		return UndoData{}, fmt.Errorf("VerifyUpdateUndo fail. Error: %w", err)
+	}
+	if uint64(len(addHashes)) > MaxNumLeaves-s.NumLeaves {
+		return UndoData{}, fmt.Errorf("VerifyUpdateUndo fail. %w. Adding %d leaves "+
+			"to %d leaves", ErrTooManyLeaves, len(addHashes), s.NumLeaves)
+	}
+
+	rootCandidates, modifiedRoots, err := calculateRootsAndDeletion(
+		DefaultHasher{}, s.NumLeaves, delHashes, proof)
+	if err != nil {
+		return UndoData{}, fmt.Errorf("VerifyUpdateUndo fail. Error: %w", err)
+	}
+	err = matchRoots(s.Roots, rootCandidates)
+	if err != nil {
+		return UndoData{}, fmt.Errorf("VerifyUpdateUndo fail. Error: %w", err)
+	}
+
+	// Copy the roots over to a new slice so that the roots of the stump that
+	// the caller may still be holding on to aren't mutated.
+	roots := make([]Hash, len(s.Roots))
+	idx := 0
+	for i := len(s.Roots) - 1; i >= 0; i-- {
+		if idx < len(rootCandidates) && s.Roots[i] == rootCandidates[idx] {
+			roots[i] = modifiedRoots[idx]
+			idx++
+		} else {
+			roots[i] = s.Roots[i]
+		}
+	}
+
+	undo := UndoData{
+		NumAdds:   uint64(len(addHashes)),
+		Targets:   make([]uint64, len(proof.Targets)),
+		DelHashes: make([]Hash, len(delHashes)),
+		PrevRoots: make([]Hash, len(s.Roots)),
+	}
+	copy(undo.Targets, proof.Targets)
+	copy(undo.DelHashes, delHashes)
+	copy(undo.PrevRoots, s.Roots)
+
+	*s = stumpAdd(DefaultHasher{}, Stump{roots, s.NumLeaves}, addHashes)
+	undo.PostCommitment = Commitment(s.Roots, s.NumLeaves)
+
+	return undo, nil
+}
+
+// hashBeforeAfter is the hash of a node at pos before and after a deletion. after
+// is empty if there's nothing left at pos after the deletion.
+type hashBeforeAfter struct {
+	pos    uint64
+	before Hash
+	after  Hash
+}
+
+// deletionParent returns the hash of the parent after the deletion. A node moves up
+// to its parent's position if its sibling was deleted.
+func deletionParent(hasher Hasher, left, right Hash) Hash {
+	switch {
+	case left == empty:
+		return right
+	case right == empty:
+		return left
+	default:
+		return hasher.ParentHash(left, right)
+	}
+}
+
+// calculateRootsAndDeletion returns the roots calculated from the proof along with
+// what each of those roots becomes once the targets are deleted. Both are ordered
+// from the lowest root to the highest root and are calculated in the same walk up
+// the forest.
+func calculateRootsAndDeletion(hasher Hasher, numLeaves uint64, delHashes []Hash,
+	proof Proof) ([]Hash, []Hash, error) {
+
+	forestRows := treeRows(numLeaves)
+
+	// The deleted targets are empty after the deletion.
+	targets := make([]hashBeforeAfter, len(proof.Targets))
+	for i, target := range proof.Targets {
+		targets[i] = hashBeforeAfter{pos: target, before: delHashes[i]}
+	}
+	sort.Slice(targets, func(a, b int) bool { return targets[a].pos < targets[b].pos })
+
+	var roots, afterRoots []Hash
+	var proves, nextProves []hashBeforeAfter
+	proofIdx, targetIdx := 0, 0
+	for row := uint8(0); row <= forestRows; row++ {
+		// Merge the targets on this row with the parents calculated from the
+		// row below. Both are sorted.
+		proves = proves[:0]
+		nextIdx := 0
+		for targetIdx < len(targets) && detectRow(targets[targetIdx].pos, forestRows) == row {
+			for nextIdx < len(nextProves) && nextProves[nextIdx].pos < targets[targetIdx].pos {
+				proves = append(proves, nextProves[nextIdx])
+				nextIdx++
+			}
+			proves = append(proves, targets[targetIdx])
+			targetIdx++
 		}
+		proves = append(proves, nextProves[nextIdx:]...)
+		nextProves = nextProves[:0]
+
+		for i := 0; i < len(proves); i++ {
+			prove := proves[i]
+
+			if isRootPosition(prove.pos, numLeaves, forestRows) {
+				roots = append(roots, prove.before)
+				afterRoots = append(afterRoots, prove.after)
+				continue
+			}
+
+			// The sibling is either the next prove or a proof hash. A proof
+			// hash isn't modified by the deletion.
+			var sib hashBeforeAfter
+			if i+1 < len(proves) && rightSib(prove.pos) == proves[i+1].pos {
+				sib = proves[i+1]
+				i++
+			} else {
+				if proofIdx >= len(proof.Proof) {
+					return nil, nil, fmt.Errorf("%w. Proof has too few hashes. "+
+						"Ran out after using all %d", ErrProofMalformed, len(proof.Proof))
+				}
+				hash := proof.Proof[proofIdx]
+				proofIdx++
+				sib = hashBeforeAfter{pos: sibling(prove.pos), before: hash, after: hash}
+			}
+
+			left, right := prove, sib
+			if !isLeftNiece(prove.pos) {
+				left, right = sib, prove
+			}
+			nextProves = append(nextProves, hashBeforeAfter{
+				pos:    parent(prove.pos, forestRows),
+				before: hasher.ParentHash(left.before, right.before),
+				after:  deletionParent(hasher, left.after, right.after),
+			})
+		}
+	}
+
+	return roots, afterRoots, nil
+}
+
+// Undo reverts the stump to the state before the update that returned the
+// undo data. The stump is left untouched if the undo data doesn't match it. The
+// undo data is checked against the stump with its PostCommitment so that the undo
+// data of a different block isn't able to be applied.
+func (s *Stump) Undo(undo UndoData) error {
+	if Commitment(s.Roots, s.NumLeaves) != undo.PostCommitment {
+		return fmt.Errorf("Stump.Undo fail. %w. The undo data isn't for the "+
+			"current state of the stump", ErrRootMismatch)
+	}
+	if len(undo.Targets) != len(undo.DelHashes) {
+		return fmt.Errorf("Stump.Undo fail. Have %d targets but %d delHashes",
+			len(undo.Targets), len(undo.DelHashes))
+	}
+	if undo.NumAdds > s.NumLeaves {
+		return fmt.Errorf("Stump.Undo fail. Have %d leaves but the undo data has %d adds",
+			s.NumLeaves, undo.NumAdds)
+	}
+
+	prevNumLeaves := s.NumLeaves - undo.NumAdds
+	if uint8(len(undo.PrevRoots)) != numRoots(prevNumLeaves) {
+		return fmt.Errorf("Stump.Undo fail. Have %d previous roots but "+
+			"numLeaves of %d should have %d roots",
+			len(undo.PrevRoots), prevNumLeaves, numRoots(prevNumLeaves))
+	}
+
+	var roots []Hash
+	if len(undo.PrevRoots) > 0 {
+		roots = make([]Hash, len(undo.PrevRoots))
+		copy(roots, undo.PrevRoots)
+	}
+	s.Roots = roots
+	s.NumLeaves = prevNumLeaves
+
+	return nil
+}
+
+// VerifyAndReturnHashes verifies the proof against the stump like StumpVerify. On
+// success, it returns the positions and the hashes of every node that was calculated
+// while hashing from the leaves up to the roots. The positions and hashes are
+// index-aligned.
+func VerifyAndReturnHashes(stump Stump, delHashes []Hash, proof Proof) ([]uint64, []Hash, error) {
+	_, intermediate, err := verifyProof(context.Background(), DefaultHasher{}, stump.Roots,
+		stump.NumLeaves, treeRows(stump.NumLeaves), delHashes, proof, true, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("VerifyAndReturnHashes fail. Error: %w", err)
+	}
+
+	positions := make([]uint64, len(intermediate))
+	hashes := make([]Hash, len(intermediate))
+	for i, node := range intermediate {
+		positions[i] = node.pos
+		hashes[i] = node.hash
+	}
+
+	return positions, hashes, nil
+}
+
+// VerifyContext is StumpVerify but stops and returns an error wrapping ctx.Err() if
+// the context is cancelled during the verification.
+func VerifyContext(ctx context.Context, stump Stump, delHashes []Hash, proof Proof) error {
+	_, _, err := verifyProof(ctx, DefaultHasher{}, stump.Roots, stump.NumLeaves,
+		treeRows(stump.NumLeaves), delHashes, proof, false, nil)
+	if err != nil {
+		return fmt.Errorf("VerifyContext fail. Error: %w", err)
 	}
 
-	return stumpAdd(Stump{roots, stump.NumLeaves}, addHashes), nil
+	return nil
 }
 
 // StumpVerify verifies the proof passed in against the passed in stump. The returned hashes
 // are the hashes that were calculated from the proof.
 func StumpVerify(stump Stump, delHashes []Hash, proof Proof) ([]Hash, error) {
+	return stumpVerify(DefaultHasher{}, stump, delHashes, proof)
+}
+
+// stumpVerify is StumpVerify but hashes with the given hasher.
+func stumpVerify(hasher Hasher, stump Stump, delHashes []Hash, proof Proof) ([]Hash, error) {
+	rootCandidates, _, err := verifyProof(context.Background(), hasher, stump.Roots,
+		stump.NumLeaves, treeRows(stump.NumLeaves), delHashes, proof, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("StumpVerify fail. Error: %w", err)
+	}
+
+	return rootCandidates, nil
+}
+
+// verifyProof verifies the proof against the roots of a forest with numLeaves and
+// totalRows. The roots must be ordered the same way GetRoots returns them and the
+// targets must be in the position space of totalRows. The returned root candidates
+// are ordered from the lowest root to the highest root. The intermediate hashes
+// are only returned if returnIntermediate is true. The buffers in scratch are used
+// for the hashing if scratch isn't nil.
+//
+// Every variant of the verification goes through verifyProof so that the checks
+// done before and after the hashing are the same for all of them.
+func verifyProof(ctx context.Context, hasher Hasher, roots []Hash, numLeaves uint64,
+	totalRows uint8, delHashes []Hash, proof Proof, returnIntermediate bool,
+	scratch *VerifyScratch) ([]Hash, []hashAndPos, error) {
+
+	err := checkProof(numLeaves, totalRows, delHashes, proof)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rootCandidates, intermediate, err := calculateHashes(ctx, hasher, numLeaves,
+		totalRows, delHashes, proof, returnIntermediate, scratch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = matchRoots(roots, rootCandidates)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rootCandidates, intermediate, nil
+}
+
+// checkProof returns an error if the proof isn't able to be verified against a
+// forest with numLeaves and totalRows. It checks that every target has a hash, that
+// the numLeaves is valid, and that none of the targets are an ancestor of another
+// target.
+func checkProof(numLeaves uint64, totalRows uint8, delHashes []Hash, proof Proof) error {
 	if len(delHashes) != len(proof.Targets) {
-		return nil, fmt.Errorf("StumpVerify fail. Was given %d targets but got %d hashes",
-			len(proof.Targets), len(delHashes))
+		return fmt.Errorf("%w. Was given %d targets but got %d hashes",
+			ErrProofMalformed, len(proof.Targets), len(delHashes))
 	}
 
-	rootCandidates := calculateRoots(stump.NumLeaves, delHashes, proof)
+	err := checkNumLeaves(numLeaves)
+	if err != nil {
+		return err
+	}
+
+	return checkTargetAncestors(proof.Targets, totalRows)
+}
+
+// matchRoots returns an error wrapping ErrRootMismatch if any of the root candidates
+// isn't one of the roots. The root candidates are ordered from the lowest root to
+// the highest root and the roots are ordered the same way GetRoots returns them.
+func matchRoots(roots, rootCandidates []Hash) error {
 	rootMatches := 0
-	for i := range stump.Roots {
+	for i := range roots {
 		if len(rootCandidates) > rootMatches &&
-			stump.Roots[len(stump.Roots)-(i+1)] == rootCandidates[rootMatches] {
+			roots[len(roots)-(i+1)] == rootCandidates[rootMatches] {
 			rootMatches++
 		}
 	}
@@ -58,24 +595,202 @@ func StumpVerify(stump Stump, delHashes []Hash, proof Proof) ([]Hash, error) {
 	if len(rootCandidates) != rootMatches {
 		// The proof is invalid because some root candidates were not
 		// included in `roots`.
-		err := fmt.Errorf("StumpVerify fail. Invalid proof. Have %d roots but only "+
-			"matched %d roots", len(rootCandidates), rootMatches)
+		return fmt.Errorf("%w. Have %d roots but only matched %d roots",
+			ErrRootMismatch, len(rootCandidates), rootMatches)
+	}
+
+	return nil
+}
+
+// VerifyAgainst verifies the proof against a historical accumulator state. This
+// allows a proof generated at an earlier block to be checked against the roots
+// committed at that block after the accumulator has moved on.
+//
+// NOTE The targets in the proof must be the positions in the forest of the
+// checkpoint's NumLeaves, not the positions in the current accumulator.
+func VerifyAgainst(checkpoint Stump, delHashes []Hash, proof Proof) error {
+	_, err := StumpVerify(checkpoint, delHashes, proof)
+	if err != nil {
+		return fmt.Errorf("VerifyAgainst fail. Error: %w", err)
+	}
+
+	return nil
+}
+
+// Verify verifies the proof against the stump like StumpVerify and returns the
+// indexes of the roots in stump.Roots that the targets hash up to. The indexes are
+// in ascending order. stump.Roots[i] is at the position
+// RootPositions(stump.NumLeaves, treeRows(stump.NumLeaves))[i] so the returned
+// indexes are also able to be used to look up the positions of the roots.
+func Verify(stump Stump, delHashes []Hash, proof Proof) ([]int, error) {
+	rootCandidates, err := StumpVerify(stump, delHashes, proof)
+	if err != nil {
 		return nil, err
 	}
 
-	return rootCandidates, nil
+	// The root candidates are ordered from the lowest root to the highest
+	// root and they all match since the proof was verified.
+	indexes := make([]int, 0, len(rootCandidates))
+	for i := len(stump.Roots) - 1; i >= 0 && len(indexes) < len(rootCandidates); i-- {
+		if stump.Roots[i] == rootCandidates[len(indexes)] {
+			indexes = append(indexes, i)
+		}
+	}
+	slices.Sort(indexes)
+
+	return indexes, nil
+}
+
+// VerifyReport describes how the roots calculated from a proof compared against
+// the roots of the stump it was verified against.
+type VerifyReport struct {
+	// Roots has an entry for every root that the targets hash up to, ordered
+	// from the lowest root to the highest root.
+	Roots []RootReport
+}
+
+// RootReport is the result of comparing a single calculated root.
+type RootReport struct {
+	// Index is the index of the root in stump.Roots.
+	Index int
+
+	// Position is the position of the root.
+	Position uint64
+
+	// Targets are the targets of the proof that are under this root.
+	Targets []uint64
+
+	// Computed is the root calculated from the proof.
+	Computed Hash
+
+	// Expected is the root in the stump.
+	Expected Hash
+
+	// Matched is true if Computed and Expected are the same.
+	Matched bool
+}
+
+// Mismatched returns the reports of the roots that didn't match.
+func (r *VerifyReport) Mismatched() []RootReport {
+	var mismatched []RootReport
+	for _, root := range r.Roots {
+		if !root.Matched {
+			mismatched = append(mismatched, root)
+		}
+	}
+
+	return mismatched
+}
+
+// VerifyVerbose verifies the proof against the stump like StumpVerify and returns
+// a report of every calculated root along with the root in the stump it was
+// compared to. If any of the roots don't match, the report is returned along with
+// an error wrapping ErrRootMismatch so that the caller is able to tell which of
+// the targets are under the roots that didn't match. The report is nil if the
+// roots couldn't be calculated from the proof.
+func VerifyVerbose(stump Stump, delHashes []Hash, proof Proof) (*VerifyReport, error) {
+	forestRows := treeRows(stump.NumLeaves)
+	err := checkProof(stump.NumLeaves, forestRows, delHashes, proof)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyVerbose fail. Error: %w", err)
+	}
+
+	rootCandidates, err := calculateRoots(stump.NumLeaves, delHashes, proof)
+	if err != nil {
+		return nil, fmt.Errorf("VerifyVerbose fail. Error: %w", err)
+	}
+
+	// Group the targets by the index of the root they're under. The root
+	// candidates are ordered from the lowest root to the highest root so go
+	// through the indexes from the highest index to the lowest.
+	rootTargets := make(map[int][]uint64)
+	for _, target := range proof.Targets {
+		tree, _, _, err := detectOffset(target, stump.NumLeaves)
+		if err != nil {
+			return nil, fmt.Errorf("VerifyVerbose fail. %w. Error: %v",
+				ErrProofMalformed, err)
+		}
+		rootTargets[int(tree)] = append(rootTargets[int(tree)], target)
+	}
+	indexes := make([]int, 0, len(rootTargets))
+	for idx := range rootTargets {
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(a, b int) bool { return indexes[a] > indexes[b] })
+
+	if len(indexes) != len(rootCandidates) {
+		return nil, fmt.Errorf("VerifyVerbose fail. %w. Calculated %d roots "+
+			"for targets under %d roots", ErrProofMalformed, len(rootCandidates), len(indexes))
+	}
+
+	positions := RootPositions(stump.NumLeaves, forestRows)
+	report := &VerifyReport{Roots: make([]RootReport, len(indexes))}
+	for i, idx := range indexes {
+		root := RootReport{
+			Index:    idx,
+			Position: positions[idx],
+			Targets:  rootTargets[idx],
+			Computed: rootCandidates[i],
+		}
+		if idx < len(stump.Roots) {
+			root.Expected = stump.Roots[idx]
+			root.Matched = root.Computed == root.Expected
+		}
+		report.Roots[i] = root
+	}
+
+	mismatched := report.Mismatched()
+	if len(mismatched) > 0 {
+		mismatchedPositions := make([]uint64, len(mismatched))
+		for i, root := range mismatched {
+			mismatchedPositions[i] = root.Position
+		}
+		return report, fmt.Errorf("VerifyVerbose fail. %w. Roots at positions "+
+			"%v didn't match", ErrRootMismatch, mismatchedPositions)
+	}
+
+	return report, nil
+}
+
+// VerifyScratch holds the buffers used during verification. Reusing the same
+// VerifyScratch for multiple calls to VerifyStream avoids allocating new buffers
+// for every proof.
+//
+// NOTE VerifyScratch is not safe for concurrent use.
+type VerifyScratch struct {
+	toProve    []hashAndPos
+	nextProves []hashAndPos
+	proves     []hashAndPos
+	roots      []Hash
+}
+
+// VerifyStream verifies the proof against the passed in stump like StumpVerify.
+// The buffers in scratch are reused to avoid allocations. A new scratch is used
+// if scratch is nil.
+func VerifyStream(stump Stump, delHashes []Hash, proof Proof, scratch *VerifyScratch) error {
+	_, _, err := verifyProof(context.Background(), DefaultHasher{}, stump.Roots,
+		stump.NumLeaves, treeRows(stump.NumLeaves), delHashes, proof, false, scratch)
+	if err != nil {
+		return fmt.Errorf("VerifyStream fail. Error: %w", err)
+	}
+
+	return nil
 }
 
 // stumpDel calculates the modified roots effected by the deletion.
-func stumpDel(numLeaves uint64, proof Proof) []Hash {
+func stumpDel(hasher Hasher, numLeaves uint64, proof Proof) []Hash {
 	delHashes, afterProof := proofAfterDeletion(numLeaves, proof)
-	roots := calculateRoots(numLeaves, delHashes, afterProof)
+
+	// The proof was already verified so the proof after the deletion always
+	// has enough hashes.
+	roots, _ := calculateRootsWithRows(hasher, numLeaves, treeRows(numLeaves),
+		delHashes, afterProof)
 	return roots
 }
 
 // stumpAdd returns a new Stump after adding the passed in adds to the previous roots
-// and numLeaves.
-func stumpAdd(stump Stump, adds []Hash) Stump {
+// and numLeaves. The new roots are hashed with the given hasher.
+func stumpAdd(hasher Hasher, stump Stump, adds []Hash) Stump {
 	for _, add := range adds {
 		// We can tell where the roots are by looking at the binary representation
 		// of the numLeaves. Wherever there's a 1, there's a root.
@@ -116,7 +831,7 @@ func stumpAdd(stump Stump, adds []Hash) Stump {
 				continue
 			} else {
 				// Calculate the hash of the new root and append it.
-				newRoot = parentHash(root, newRoot)
+				newRoot = hasher.ParentHash(root, newRoot)
 			}
 		}
 		stump.Roots = append(stump.Roots, newRoot)
@@ -125,3 +840,114 @@ func stumpAdd(stump Stump, adds []Hash) Stump {
 
 	return stump
 }
+
+// LeafAtPosition replays the operations on top of the genesis stump and returns
+// the hash of the leaf that is located at targetPos after all the operations have
+// been applied. false is returned if the position isn't occupied by a leaf.
+//
+// NOTE Only the leaves that were added in the operations are tracked. The hashes
+// of the leaves already present in the genesis stump are unknown so false is
+// also returned for the positions they occupy.
+func LeafAtPosition(targetPos uint64, genesis Stump, operations []ChainUpdate) (Hash, bool, error) {
+	stump := Stump{make([]Hash, len(genesis.Roots)), genesis.NumLeaves}
+	copy(stump.Roots, genesis.Roots)
+
+	// All the leaves that we know the positions of.
+	var tracked []hashAndPos
+
+	for i, op := range operations {
+		forestRows := treeRows(stump.NumLeaves)
+		tracked = trackedAfterDeletion(tracked, stump.NumLeaves, forestRows, op.Proof.Targets)
+
+		// Update calls StumpVerify so the proof is verified here as well.
+		_, err := stump.Update(op.DelHashes, nil, op.Proof)
+		if err != nil {
+			return empty, false, fmt.Errorf("LeafAtPosition fail at operation %d. "+
+				"Error: %v", i, err)
+		}
+
+		for _, add := range op.Adds {
+			tracked = trackedAfterAddition(tracked, stump, add)
+			stump = stumpAdd(DefaultHasher{}, stump, []Hash{add})
+		}
+	}
+
+	for _, leaf := range tracked {
+		if leaf.pos == targetPos {
+			return leaf.hash, true, nil
+		}
+	}
+
+	return empty, false, nil
+}
+
+// trackedAfterDeletion returns the tracked leaves with their positions updated after
+// the targets have been deleted. The tracked leaves that are deleted are removed.
+func trackedAfterDeletion(tracked []hashAndPos, numLeaves uint64, forestRows uint8,
+	targets []uint64) []hashAndPos {
+
+	dels := make([]uint64, len(targets))
+	copy(dels, targets)
+	sort.Slice(dels, func(a, b int) bool { return dels[a] < dels[b] })
+	dels = deTwin(dels, forestRows)
+
+	for _, del := range dels {
+		// Remove the leaves that are being deleted.
+		for i := 0; i < len(tracked); i++ {
+			if tracked[i].pos == del || isAncestor(del, tracked[i].pos, forestRows) {
+				tracked = append(tracked[:i], tracked[i+1:]...)
+				i--
+			}
+		}
+
+		// If a root is deleted, nothing moves up.
+		if isRootPosition(del, numLeaves, forestRows) {
+			continue
+		}
+
+		// The sibling and its descendants move up by one row.
+		sib := sibling(del)
+		for i := range tracked {
+			if tracked[i].pos == sib || isAncestor(sib, tracked[i].pos, forestRows) {
+				// Ignore the error since we've already checked that sib
+				// is an ancestor.
+				tracked[i].pos, _ = calcNextPosition(tracked[i].pos, sib, forestRows)
+			}
+		}
+	}
+
+	return tracked
+}
+
+// trackedAfterAddition returns the tracked leaves with their positions updated after
+// the add is added to the stump. The add is included in the returned leaves.
+func trackedAfterAddition(tracked []hashAndPos, stump Stump, add Hash) []hashAndPos {
+	// Translate all the positions if the forest grows.
+	forestRows := treeRows(stump.NumLeaves)
+	newRows := treeRows(stump.NumLeaves + 1)
+	if forestRows != newRows {
+		for i := range tracked {
+			tracked[i].pos = translatePos(tracked[i].pos, forestRows, newRows)
+		}
+	}
+
+	tracked = append(tracked, hashAndPos{add, stump.NumLeaves})
+
+	// Follow what stumpAdd does. If the root being hashed with is empty, the
+	// new root and its descendants move up by one row.
+	pos := stump.NumLeaves
+	rootIdx := len(stump.Roots) - 1
+	for h := uint8(0); (stump.NumLeaves>>h)&1 == 1; h++ {
+		if stump.Roots[rootIdx] == empty {
+			for i := range tracked {
+				if tracked[i].pos == pos || isAncestor(pos, tracked[i].pos, newRows) {
+					tracked[i].pos, _ = calcNextPosition(tracked[i].pos, pos, newRows)
+				}
+			}
+		}
+		pos = parent(pos, newRows)
+		rootIdx--
+	}
+
+	return tracked
+}
@@ -1,7 +1,15 @@
 package utreexo
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"math/rand"
+	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -180,3 +188,976 @@ func FuzzStumpChain(f *testing.F) {
 		}
 	})
 }
+
+func TestStumpUpdate(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+
+	p := NewAccumulator(true)
+	stump := Stump{}
+
+	for b := 0; b <= 50; b++ {
+		adds, _, delHashes := sc.NextBlock(3)
+
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestStumpUpdate fail at block %d. Error: %v", b, err)
+		}
+
+		addHashes := make([]Hash, len(adds))
+		for i := range addHashes {
+			addHashes[i] = adds[i].Hash
+		}
+
+		// An invalid proof shouldn't modify the stump.
+		if len(delHashes) > 0 {
+			badHashes := make([]Hash, len(delHashes))
+			copy(badHashes, delHashes)
+			badHashes[0][31] ^= 0xFF
+
+			before := Stump{append([]Hash(nil), stump.Roots...), stump.NumLeaves}
+			_, err = stump.Update(badHashes, addHashes, proof)
+			if err == nil {
+				t.Fatalf("TestStumpUpdate fail at block %d. Expected an error "+
+					"for an invalid proof", b)
+			}
+			if !reflect.DeepEqual(before, stump) {
+				t.Fatalf("TestStumpUpdate fail at block %d. Stump modified "+
+					"after an invalid proof", b)
+			}
+		}
+
+		_, err = stump.Update(delHashes, addHashes, proof)
+		if err != nil {
+			t.Fatalf("TestStumpUpdate fail at block %d. Error: %v", b, err)
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestStumpUpdate fail at block %d. Error: %v", b, err)
+		}
+
+		if stump.NumLeaves != p.numLeaves {
+			t.Fatalf("TestStumpUpdate fail at block %d. Expected %d leaves, got %d",
+				b, p.numLeaves, stump.NumLeaves)
+		}
+		if !reflect.DeepEqual(stump.Roots, p.GetRoots()) {
+			t.Fatalf("TestStumpUpdate fail at block %d: Roots do not equal between pollard and stump."+
+				"\nStump:\n%s\nPollard:\n%s\n", b,
+				printHashes(stump.Roots), printHashes(p.GetRoots()))
+		}
+	}
+}
+
+func TestStumpSerialize(t *testing.T) {
+	t.Parallel()
+
+	var tests []Stump
+	tests = append(tests, Stump{})
+	for _, numAdds := range []uint32{1, 2, 7, 8, 15, 100} {
+		adds, _, _ := getAddsAndDels(0, numAdds, 0)
+		addHashes := make([]Hash, len(adds))
+		for i := range adds {
+			addHashes[i] = adds[i].Hash
+		}
+		tests = append(tests, stumpAdd(DefaultHasher{}, Stump{}, addHashes))
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		n, err := test.Serialize(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != buf.Len() {
+			t.Fatalf("TestStumpSerialize fail. Wrote %d bytes but buffer has %d bytes",
+				n, buf.Len())
+		}
+		serialized := buf.Bytes()
+
+		// The roots should be written in the canonical order.
+		canonical := CanonicalRootOrder(test.Roots, test.NumLeaves)
+		for i, root := range canonical {
+			start := 9 + i*32
+			if !bytes.Equal(serialized[start:start+32], root[:]) {
+				t.Fatalf("TestStumpSerialize fail. Root %d isn't in canonical order", i)
+			}
+		}
+
+		var got Stump
+		err = got.Deserialize(bytes.NewReader(serialized))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test, got) {
+			t.Fatalf("TestStumpSerialize fail. Expected %v, got %v", test, got)
+		}
+
+		// Deserializing a truncated stream should error and leave the
+		// stump untouched.
+		for i := 0; i < len(serialized); i++ {
+			truncated := Stump{Roots: []Hash{{1}}, NumLeaves: 1}
+			err = truncated.Deserialize(bytes.NewReader(serialized[:i]))
+			if err == nil {
+				t.Fatalf("TestStumpSerialize fail. Expected error for "+
+					"stream truncated to %d bytes", i)
+			}
+			if !reflect.DeepEqual(truncated, Stump{Roots: []Hash{{1}}, NumLeaves: 1}) {
+				t.Fatalf("TestStumpSerialize fail. Stump modified after failed deserialize")
+			}
+		}
+	}
+
+	// A stump with the wrong count of roots can't be serialized.
+	var buf bytes.Buffer
+	_, err := (&Stump{Roots: []Hash{{1}}, NumLeaves: 3}).Serialize(&buf)
+	if err == nil {
+		t.Fatalf("TestStumpSerialize fail. Expected an error for a mismatched root count")
+	}
+}
+
+func TestLeafAtPosition(t *testing.T) {
+	t.Parallel()
+
+	for _, seed := range []int64{0, 1, 2, 424} {
+		sc := newSimChainWithSeed(0x07, seed)
+		p := NewAccumulator(true)
+
+		var genesis Stump
+		var operations []ChainUpdate
+		added := make(map[Hash]struct{})
+		for b := 0; b <= 40; b++ {
+			adds, _, delHashes := sc.NextBlock(3)
+
+			proof, err := p.Prove(delHashes)
+			if err != nil {
+				t.Fatalf("TestLeafAtPosition fail at block %d. Error: %v", b, err)
+			}
+
+			// Start recording operations after a few blocks.
+			if b == 5 {
+				genesis = Stump{p.GetRoots(), p.numLeaves}
+			}
+			if b >= 5 {
+				addHashes := make([]Hash, len(adds))
+				for i := range addHashes {
+					addHashes[i] = adds[i].Hash
+					added[adds[i].Hash] = struct{}{}
+				}
+				operations = append(operations, ChainUpdate{addHashes, delHashes, proof})
+			}
+
+			err = p.Modify(adds, delHashes, proof.Targets)
+			if err != nil {
+				t.Fatalf("TestLeafAtPosition fail at block %d. Error: %v", b, err)
+			}
+		}
+
+		// Every leaf added after genesis should be found at the position the
+		// pollard has it at.
+		for _, node := range p.nodeMap {
+			pos := p.calculatePosition(node)
+			hash, found, err := LeafAtPosition(pos, genesis, operations)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, wasAdded := added[node.data]
+			if found != wasAdded {
+				t.Fatalf("TestLeafAtPosition fail with seed %d. Expected found %v "+
+					"for position %d, got %v", seed, wasAdded, pos, found)
+			}
+			if found && hash != node.data {
+				t.Fatalf("TestLeafAtPosition fail with seed %d. Expected %x at "+
+					"position %d, got %x", seed, node.data, pos, hash)
+			}
+		}
+
+		// A position that's never been allocated is unoccupied.
+		_, found, err := LeafAtPosition(p.numLeaves, genesis, operations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found {
+			t.Fatalf("TestLeafAtPosition fail. Position %d shouldn't be occupied",
+				p.numLeaves)
+		}
+	}
+}
+
+func TestVerifyStream(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x0f, 0)
+	p := NewAccumulator(true)
+
+	// Reuse the same scratch for every block.
+	var scratch VerifyScratch
+	for b := 0; b <= 50; b++ {
+		adds, _, delHashes := sc.NextBlock(7)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestVerifyStream fail at block %d. Error: %v", b, err)
+		}
+
+		stump := Stump{p.GetRoots(), p.numLeaves}
+		_, err = StumpVerify(stump, delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestVerifyStream fail at block %d. Error: %v", b, err)
+		}
+		err = VerifyStream(stump, delHashes, proof, &scratch)
+		if err != nil {
+			t.Fatalf("TestVerifyStream fail at block %d. Error: %v", b, err)
+		}
+
+		expected, err := calculateRoots(p.numLeaves, delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestVerifyStream fail at block %d. Error: %v", b, err)
+		}
+		got, _, err := calculateHashes(context.Background(), DefaultHasher{},
+			p.numLeaves, treeRows(p.numLeaves), delHashes, proof, false, &scratch)
+		if err != nil {
+			t.Fatalf("TestVerifyStream fail at block %d. Error: %v", b, err)
+		}
+		if len(expected) != len(got) || (len(got) > 0 && !reflect.DeepEqual(expected, got)) {
+			t.Fatalf("TestVerifyStream fail at block %d. Expected roots:\n%s\ngot:\n%s",
+				b, printHashes(expected), printHashes(got))
+		}
+
+		if len(delHashes) > 0 {
+			badHashes := make([]Hash, len(delHashes))
+			copy(badHashes, delHashes)
+			badHashes[len(badHashes)-1][0] ^= 0xFF
+
+			_, err = StumpVerify(stump, badHashes, proof)
+			if err == nil {
+				t.Fatalf("TestVerifyStream fail at block %d. Expected an error", b)
+			}
+			err = VerifyStream(stump, badHashes, proof, &scratch)
+			if err == nil {
+				t.Fatalf("TestVerifyStream fail at block %d. Expected an error", b)
+			}
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestVerifyStream fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func BenchmarkVerifyStream(b *testing.B) {
+	rand.Seed(0)
+
+	p := NewAccumulator(true)
+	leaves, delHashes, _ := getAddsAndDels(uint32(p.numLeaves), 10000, 2000)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stump := Stump{p.GetRoots(), p.numLeaves}
+
+	b.Run("Verify", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := p.Verify(delHashes, proof)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("VerifyStream", func(b *testing.B) {
+		var scratch VerifyScratch
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := VerifyStream(stump, delHashes, proof, &scratch)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestVerifyAndReturnHashes(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x0f, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 50; b++ {
+		adds, _, delHashes := sc.NextBlock(7)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestVerifyAndReturnHashes fail at block %d. Error: %v", b, err)
+		}
+
+		stump := Stump{p.GetRoots(), p.numLeaves}
+		positions, hashes, err := VerifyAndReturnHashes(stump, delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestVerifyAndReturnHashes fail at block %d. Error: %v", b, err)
+		}
+		if len(positions) != len(hashes) {
+			t.Fatalf("TestVerifyAndReturnHashes fail at block %d. Got %d positions "+
+				"but %d hashes", b, len(positions), len(hashes))
+		}
+
+		// Every ancestor of the targets should be returned.
+		forestRows := treeRows(p.numLeaves)
+		expected := make(map[uint64]struct{})
+		for _, target := range proof.Targets {
+			for pos := target; !isRootPosition(pos, p.numLeaves, forestRows); {
+				pos = parent(pos, forestRows)
+				expected[pos] = struct{}{}
+			}
+		}
+		if len(expected) != len(positions) {
+			t.Fatalf("TestVerifyAndReturnHashes fail at block %d. Expected %d "+
+				"positions but got %d", b, len(expected), len(positions))
+		}
+		for i, pos := range positions {
+			if _, found := expected[pos]; !found {
+				t.Fatalf("TestVerifyAndReturnHashes fail at block %d. Position %d "+
+					"isn't an ancestor of the targets %v", b, pos, proof.Targets)
+			}
+			if p.getHash(pos) != hashes[i] {
+				t.Fatalf("TestVerifyAndReturnHashes fail at block %d. Expected "+
+					"hash %s at position %d but got %s", b, p.getHash(pos), pos, hashes[i])
+			}
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestVerifyAndReturnHashes fail at block %d. Error: %v", b, err)
+		}
+	}
+
+	// An invalid proof shouldn't return any hashes.
+	var proven Hash
+	for _, node := range p.nodeMap {
+		proven = node.data
+		break
+	}
+	proof, err := p.Prove([]Hash{proven})
+	if err != nil {
+		t.Fatal(err)
+	}
+	positions, hashes, err := VerifyAndReturnHashes(Stump{p.GetRoots(), p.numLeaves},
+		[]Hash{{1}}, proof)
+	if err == nil || positions != nil || hashes != nil {
+		t.Fatalf("TestVerifyAndReturnHashes fail. Expected an invalid proof to error")
+	}
+}
+
+func TestStumpVerifyUpdateUndo(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChainWithSeed(0x07, 0)
+	p := NewAccumulator(true)
+	stump := Stump{}
+
+	var prevUndo UndoData
+	for b := 0; b <= 100; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Error: %v", b, err)
+		}
+
+		addHashes := make([]Hash, len(adds))
+		for i := range addHashes {
+			addHashes[i] = adds[i].Hash
+		}
+
+		// The verification and the roots after the deletion are calculated
+		// in a single walk so there's at most 2 hashes per node that the
+		// verification visits.
+		verifyCounter := countingHasher{hasher: DefaultHasher{}}
+		_, err = calculateRootsWithRows(&verifyCounter, stump.NumLeaves,
+			treeRows(stump.NumLeaves), delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counter := countingHasher{hasher: DefaultHasher{}}
+		_, _, err = calculateRootsAndDeletion(&counter, stump.NumLeaves, delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counter.count < verifyCounter.count || counter.count > 2*verifyCounter.count {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Verification took "+
+				"%d hashes but the single pass took %d", b, verifyCounter.count, counter.count)
+		}
+
+		// An invalid proof should leave the stump untouched.
+		if len(delHashes) > 0 {
+			wrong := make([]Hash, len(delHashes))
+			copy(wrong, delHashes)
+			wrong[0][0] ^= 0xff
+			untouched := Stump{append([]Hash(nil), stump.Roots...), stump.NumLeaves}
+			_, err = untouched.VerifyUpdateUndo(wrong, addHashes, proof)
+			if !errors.Is(err, ErrRootMismatch) {
+				t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Expected %v, got %v",
+					b, ErrRootMismatch, err)
+			}
+			if !reflect.DeepEqual(untouched.Roots, stump.Roots) ||
+				untouched.NumLeaves != stump.NumLeaves {
+				t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Stump was "+
+					"modified by an invalid proof", b)
+			}
+		}
+
+		before := Stump{append([]Hash(nil), stump.Roots...), stump.NumLeaves}
+		updated := Stump{append([]Hash(nil), stump.Roots...), stump.NumLeaves}
+		_, err = updated.Update(delHashes, addHashes, proof)
+		if err != nil {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Error: %v", b, err)
+		}
+		undo, err := stump.VerifyUpdateUndo(delHashes, addHashes, proof)
+		if err != nil {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Error: %v", b, err)
+		}
+		if !reflect.DeepEqual(stump, updated) {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Expected the same "+
+				"stump as Update:\n%s\ngot:\n%s", b, printHashes(updated.Roots),
+				printHashes(stump.Roots))
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Error: %v", b, err)
+		}
+		if !reflect.DeepEqual(stump.Roots, p.GetRoots()) {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Expected roots:\n%s\ngot:\n%s",
+				b, printHashes(p.GetRoots()), printHashes(stump.Roots))
+		}
+
+		// The undo data of the previous block shouldn't be able to be applied.
+		undone := Stump{append([]Hash(nil), stump.Roots...), stump.NumLeaves}
+		if b > 0 {
+			err = undone.Undo(prevUndo)
+			if !errors.Is(err, ErrRootMismatch) {
+				t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Expected %v, got %v",
+					b, ErrRootMismatch, err)
+			}
+			if !reflect.DeepEqual(stump, undone) {
+				t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Stump was "+
+					"modified by the wrong undo data", b)
+			}
+		}
+		prevUndo = undo
+
+		// Undo the block and make sure the stump is back to what it was.
+		err = undone.Undo(undo)
+		if err != nil {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Error: %v", b, err)
+		}
+		if !reflect.DeepEqual(before, undone) {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Expected:\n%s\ngot:\n%s",
+				b, printHashes(before.Roots), printHashes(undone.Roots))
+		}
+
+		// The undone stump should be able to apply the block again.
+		_, err = undone.Update(delHashes, addHashes, proof)
+		if err != nil {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Error: %v", b, err)
+		}
+		if !reflect.DeepEqual(stump, undone) {
+			t.Fatalf("TestStumpVerifyUpdateUndo fail at block %d. Stumps differ "+
+				"after re-applying the block", b)
+		}
+	}
+}
+
+func TestIsAncestorOf(t *testing.T) {
+	t.Parallel()
+
+	// Returns the stumps at every block and the updates made in every block.
+	buildChain := func(seed int64) ([]Stump, []ChainUpdate) {
+		sc := newSimChainWithSeed(0x07, seed)
+		p := NewAccumulator(true)
+		stumps := []Stump{{}}
+		var updates []ChainUpdate
+		for b := 0; b < 20; b++ {
+			adds, _, delHashes := sc.NextBlock(5)
+			proof, err := p.Prove(delHashes)
+			if err != nil {
+				t.Fatalf("TestIsAncestorOf fail at block %d. Error: %v", b, err)
+			}
+			err = p.Modify(adds, delHashes, proof.Targets)
+			if err != nil {
+				t.Fatalf("TestIsAncestorOf fail at block %d. Error: %v", b, err)
+			}
+
+			addHashes := make([]Hash, len(adds))
+			for i := range addHashes {
+				addHashes[i] = adds[i].Hash
+			}
+			updates = append(updates, ChainUpdate{addHashes, delHashes, proof})
+			stumps = append(stumps, Stump{p.GetRoots(), p.numLeaves})
+		}
+
+		return stumps, updates
+	}
+
+	stumps, updates := buildChain(0)
+	divergent, _ := buildChain(1)
+
+	consistency := ConsistencyProof{updates[5:15]}
+	if !stumps[5].IsAncestorOf(stumps[15], consistency) {
+		t.Fatalf("TestIsAncestorOf fail. Expected stump at 5 to be an ancestor " +
+			"of the stump at 15")
+	}
+	if stumps[5].IsAncestorOf(stumps[14], consistency) {
+		t.Fatalf("TestIsAncestorOf fail. Expected stump at 5 to not be an " +
+			"ancestor of the stump at 14 with the updates up to 15")
+	}
+	if stumps[5].IsAncestorOf(divergent[15], consistency) {
+		t.Fatalf("TestIsAncestorOf fail. Expected stump at 5 to not be an " +
+			"ancestor of a divergent stump")
+	}
+	if divergent[5].IsAncestorOf(stumps[15], consistency) {
+		t.Fatalf("TestIsAncestorOf fail. Expected a divergent stump to not be " +
+			"an ancestor")
+	}
+	if !stumps[7].IsAncestorOf(stumps[7], ConsistencyProof{}) {
+		t.Fatalf("TestIsAncestorOf fail. Expected a stump to be an ancestor " +
+			"of itself with no updates")
+	}
+}
+
+func TestCommitmentOrder(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 27, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The commitment should be over the roots in ascending position order, which
+	// is the reverse of the GetRoots order.
+	roots, positions := p.GetRootsWithPositions()
+	ascending := make([]hashAndPos, len(roots))
+	for i := range roots {
+		ascending[i] = hashAndPos{roots[i], positions[i]}
+	}
+	sort.Slice(ascending, func(a, b int) bool { return ascending[a].pos < ascending[b].pos })
+
+	h := sha512.New512_256()
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], p.numLeaves)
+	h.Write(buf[:])
+	for _, root := range ascending {
+		h.Write(root.hash[:])
+	}
+	expected := *((*Hash)(h.Sum(nil)))
+
+	got := Commitment(p.GetRoots(), p.numLeaves)
+	if got != expected {
+		t.Fatalf("TestCommitmentOrder fail. Expected %s, got %s",
+			hex.EncodeToString(expected[:]), hex.EncodeToString(got[:]))
+	}
+
+	// A root count that doesn't match the numLeaves should give an empty hash.
+	if Commitment(p.GetRoots()[1:], p.numLeaves) != empty {
+		t.Fatalf("TestCommitmentOrder fail. Expected an empty hash for a " +
+			"mismatched root count")
+	}
+}
+
+func TestVerifyAgainstCommitment(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 27, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := p.GetRoots()
+	commitment := Commitment(roots, p.numLeaves)
+
+	delHashes := []Hash{leaves[3].Hash, leaves[20].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyAgainstCommitment(commitment, p.numLeaves, roots, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tampered root list shouldn't match the commitment even if the proof
+	// doesn't touch the tampered root.
+	tampered := make([]Hash, len(roots))
+	copy(tampered, roots)
+	tampered[len(tampered)-1][0] ^= 0xff
+	err = VerifyAgainstCommitment(commitment, p.numLeaves, tampered, delHashes, proof)
+	if err == nil {
+		t.Fatalf("TestVerifyAgainstCommitment fail. Expected an error for tampered roots")
+	}
+
+	// A different numLeaves shouldn't match either.
+	err = VerifyAgainstCommitment(commitment, p.numLeaves+2, roots, delHashes, proof)
+	if err == nil {
+		t.Fatalf("TestVerifyAgainstCommitment fail. Expected an error for a wrong numLeaves")
+	}
+
+	// An invalid proof against the right roots should fail.
+	err = VerifyAgainstCommitment(commitment, p.numLeaves, roots,
+		[]Hash{leaves[4].Hash, leaves[20].Hash}, proof)
+	if err == nil {
+		t.Fatalf("TestVerifyAgainstCommitment fail. Expected an error for an invalid proof")
+	}
+}
+
+func TestVerifyContext(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 1<<12, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Prove every third leaf so that there's a lot of work to do.
+	var delHashes []Hash
+	for i := 0; i < len(leaves); i += 3 {
+		delHashes = append(delHashes, leaves[i].Hash)
+	}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyContext(context.Background(), p.ToStump(), delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = VerifyContext(ctx, p.ToStump(), delHashes, proof)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("TestVerifyContext fail. Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestDiffStumps(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 12, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := p.ToStump()
+
+	added, changed := DiffStumps(old, old)
+	if added != 0 || changed {
+		t.Fatalf("TestDiffStumps fail. Expected (0, false) for the same stump, "+
+			"got (%d, %v)", added, changed)
+	}
+
+	// 12 leaves have roots at rows 3 and 2. Adding 2 leaves keeps both of those
+	// roots and adds a root at row 1.
+	adds, _, _ := getAddsAndDels(uint32(p.numLeaves), 2, 0)
+	err = p.Modify(adds, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, changed = DiffStumps(old, p.ToStump())
+	if added != 1 || !changed {
+		t.Fatalf("TestDiffStumps fail. Expected (1, true), got (%d, %v)",
+			added, changed)
+	}
+
+	// Stumps that don't share any subtrees have all their roots counted.
+	other := NewAccumulator(true)
+	otherLeaves := make([]Leaf, 7)
+	for i := range otherLeaves {
+		otherLeaves[i] = Leaf{Hash: Hash{0xaa, uint8(i)}}
+	}
+	err = other.Modify(otherLeaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, changed = DiffStumps(old, other.ToStump())
+	if added != 3 || !changed {
+		t.Fatalf("TestDiffStumps fail. Expected (3, true), got (%d, %v)",
+			added, changed)
+	}
+}
+
+func TestVerifyRootIndexes(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 15, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump := p.ToStump()
+
+	// 15 leaves have roots at rows 3, 2, 1, and 0. Leaf 2 is under the first
+	// root and leaf 12 is under the third root.
+	delHashes := []Hash{leaves[12].Hash, leaves[2].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexes, err := Verify(stump, delHashes, proof)
+	if err != nil {
+		t.Fatalf("TestVerifyRootIndexes fail. Error: %v", err)
+	}
+	expected := []int{0, 2}
+	if !reflect.DeepEqual(indexes, expected) {
+		t.Fatalf("TestVerifyRootIndexes fail. Expected %v, got %v", expected, indexes)
+	}
+
+	// The indexes map to the root positions that the targets are under.
+	forestRows := treeRows(stump.NumLeaves)
+	rootPositions := RootPositions(stump.NumLeaves, forestRows)
+	for i, target := range []uint64{2, 12} {
+		pos := target
+		for !isRootPosition(pos, stump.NumLeaves, forestRows) {
+			pos = parent(pos, forestRows)
+		}
+		if rootPositions[indexes[i]] != pos {
+			t.Fatalf("TestVerifyRootIndexes fail. Expected root position %d "+
+				"for target %d, got %d", pos, target, rootPositions[indexes[i]])
+		}
+	}
+
+	_, err = Verify(stump, []Hash{leaves[13].Hash, leaves[2].Hash}, proof)
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("TestVerifyRootIndexes fail. Expected ErrRootMismatch, got: %v", err)
+	}
+}
+
+func TestVerifyAgainst(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 10, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := p.ToStump()
+
+	delHashes := []Hash{leaves[3].Hash, leaves[8].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the accumulator forward so that the proof no longer verifies
+	// against the current state.
+	adds, _, _ := getAddsAndDels(uint32(p.numLeaves), 7, 0)
+	err = p.Modify(adds, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyAgainst(checkpoint, delHashes, proof)
+	if err != nil {
+		t.Fatalf("TestVerifyAgainst fail. Error: %v", err)
+	}
+	err = VerifyAgainst(p.ToStump(), delHashes, proof)
+	if err == nil {
+		t.Fatalf("TestVerifyAgainst fail. Expected the proof to not verify " +
+			"against the current state")
+	}
+}
+
+func TestStumpVerifier(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	var acc Utreexo = &p
+	var verifier Verifier = &Stump{}
+
+	sc := newSimChainWithSeed(0x07, 0)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		proof, err := acc.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestStumpVerifier fail at block %d. Error: %v", b, err)
+		}
+
+		err = verifier.Verify(delHashes, proof)
+		if err != nil {
+			t.Fatalf("TestStumpVerifier fail at block %d. Error: %v", b, err)
+		}
+		addHashes := make([]Hash, len(adds))
+		for i := range adds {
+			addHashes[i] = adds[i].Hash
+		}
+		_, err = verifier.Update(delHashes, addHashes, proof)
+		if err != nil {
+			t.Fatalf("TestStumpVerifier fail at block %d. Error: %v", b, err)
+		}
+
+		err = acc.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestStumpVerifier fail at block %d. Error: %v", b, err)
+		}
+		if !reflect.DeepEqual(verifier.GetRoots(), acc.GetRoots()) {
+			t.Fatalf("TestStumpVerifier fail at block %d. Expected roots:\n%s\ngot:\n%s",
+				b, printHashes(acc.GetRoots()), printHashes(verifier.GetRoots()))
+		}
+	}
+}
+
+func TestStumpTooManyLeaves(t *testing.T) {
+	t.Parallel()
+
+	// The last leaf of a forest with the most leaves that aren't a power
+	// of 2 is the lowest root.
+	leaf := Hash{1}
+	stump := Stump{Roots: make([]Hash, 63), NumLeaves: MaxNumLeaves - 1}
+	stump.Roots[62] = leaf
+	proof := Proof{Targets: []uint64{MaxNumLeaves - 2}}
+	err := stump.Verify([]Hash{leaf}, proof)
+	if err != nil {
+		t.Fatalf("TestStumpTooManyLeaves fail. Error: %v", err)
+	}
+
+	// A proof for the first leaf with bogus hashes shouldn't verify.
+	proof = Proof{Targets: []uint64{0}, Proof: make([]Hash, 62)}
+	err = stump.Verify([]Hash{leaf}, proof)
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("TestStumpTooManyLeaves fail. Expected %v, got %v", ErrRootMismatch, err)
+	}
+
+	// Going over MaxNumLeaves should be an error.
+	full := Stump{Roots: []Hash{leaf}, NumLeaves: MaxNumLeaves}
+	_, err = full.Update(nil, []Hash{{2}}, Proof{})
+	if !errors.Is(err, ErrTooManyLeaves) {
+		t.Fatalf("TestStumpTooManyLeaves fail. Expected %v, got %v", ErrTooManyLeaves, err)
+	}
+	if full.NumLeaves != MaxNumLeaves {
+		t.Fatalf("TestStumpTooManyLeaves fail. Stump was modified on error")
+	}
+
+	for _, numLeaves := range []uint64{MaxNumLeaves + 1, 1<<64 - 1} {
+		s := Stump{Roots: []Hash{leaf}, NumLeaves: numLeaves}
+		proof := Proof{Targets: []uint64{0}}
+
+		_, err := StumpVerify(s, []Hash{leaf}, proof)
+		if !errors.Is(err, ErrTooManyLeaves) {
+			t.Fatalf("TestStumpTooManyLeaves fail. Expected %v, got %v", ErrTooManyLeaves, err)
+		}
+		err = VerifyStream(s, []Hash{leaf}, proof, nil)
+		if !errors.Is(err, ErrTooManyLeaves) {
+			t.Fatalf("TestStumpTooManyLeaves fail. Expected %v, got %v", ErrTooManyLeaves, err)
+		}
+		err = VerifyContext(context.Background(), s, []Hash{leaf}, proof)
+		if !errors.Is(err, ErrTooManyLeaves) {
+			t.Fatalf("TestStumpTooManyLeaves fail. Expected %v, got %v", ErrTooManyLeaves, err)
+		}
+		_, _, err = VerifyAndReturnHashes(s, []Hash{leaf}, proof)
+		if !errors.Is(err, ErrTooManyLeaves) {
+			t.Fatalf("TestStumpTooManyLeaves fail. Expected %v, got %v", ErrTooManyLeaves, err)
+		}
+	}
+}
+
+func TestVerifyVerbose(t *testing.T) {
+	t.Parallel()
+
+	// 20 leaves make a tree of 16 leaves and a tree of 4 leaves.
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 20, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump := p.ToStump()
+
+	delHashes := []Hash{leaves[3].Hash, leaves[17].Hash, leaves[9].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyVerbose(stump, delHashes, proof)
+	if err != nil {
+		t.Fatalf("TestVerifyVerbose fail. Error: %v", err)
+	}
+	if len(report.Roots) != 2 {
+		t.Fatalf("TestVerifyVerbose fail. Expected 2 roots in the report, got %d",
+			len(report.Roots))
+	}
+	if len(report.Mismatched()) != 0 {
+		t.Fatalf("TestVerifyVerbose fail. Expected all roots to match")
+	}
+
+	// The lowest root comes first.
+	rootPositions := RootPositions(stump.NumLeaves, treeRows(stump.NumLeaves))
+	if report.Roots[0].Index != 1 || report.Roots[0].Position != rootPositions[1] ||
+		!reflect.DeepEqual(report.Roots[0].Targets, []uint64{17}) {
+		t.Fatalf("TestVerifyVerbose fail. Unexpected report for the lower root: %+v",
+			report.Roots[0])
+	}
+	if report.Roots[1].Index != 0 || report.Roots[1].Position != rootPositions[0] ||
+		!reflect.DeepEqual(report.Roots[1].Targets, []uint64{3, 9}) {
+		t.Fatalf("TestVerifyVerbose fail. Unexpected report for the higher root: %+v",
+			report.Roots[1])
+	}
+
+	// Corrupt a proof hash under the lower root. Only the lower root should
+	// be reported as mismatched.
+	positions, _ := proofPositions(sortedTargets(proof.Targets), stump.NumLeaves,
+		treeRows(stump.NumLeaves))
+	corrupted := Proof{Targets: proof.Targets, Proof: make([]Hash, len(proof.Proof))}
+	copy(corrupted.Proof, proof.Proof)
+	for i, pos := range positions {
+		tree, _, _, err := detectOffset(pos, stump.NumLeaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree == 1 {
+			corrupted.Proof[i][0] ^= 0xff
+			break
+		}
+	}
+
+	report, err = VerifyVerbose(stump, delHashes, corrupted)
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("TestVerifyVerbose fail. Expected %v, got %v", ErrRootMismatch, err)
+	}
+	if report == nil {
+		t.Fatalf("TestVerifyVerbose fail. Expected a report along with the error")
+	}
+	mismatched := report.Mismatched()
+	if len(mismatched) != 1 || mismatched[0].Position != rootPositions[1] {
+		t.Fatalf("TestVerifyVerbose fail. Expected only the root at %d to mismatch, "+
+			"got %+v", rootPositions[1], mismatched)
+	}
+	if mismatched[0].Expected != stump.Roots[1] || mismatched[0].Computed == stump.Roots[1] {
+		t.Fatalf("TestVerifyVerbose fail. Unexpected hashes for the mismatched root")
+	}
+	if !report.Roots[1].Matched {
+		t.Fatalf("TestVerifyVerbose fail. Expected the higher root to still match")
+	}
+
+	// A proof missing hashes can't be used to calculate the roots.
+	report, err = VerifyVerbose(stump, delHashes, Proof{Targets: proof.Targets})
+	if !errors.Is(err, ErrProofMalformed) || report != nil {
+		t.Fatalf("TestVerifyVerbose fail. Expected %v and no report, got %v",
+			ErrProofMalformed, err)
+	}
+}
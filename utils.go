@@ -7,9 +7,26 @@ import (
 	"math"
 	"math/bits"
 	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
 )
 
-// parentHash returns the hash of the left and right hashes passed in.
+// Hasher calculates the hash of a parent from the hashes of its children.
+type Hasher interface {
+	// ParentHash returns the hash of the parent of the left and right hashes.
+	ParentHash(left, right Hash) Hash
+}
+
+// DefaultHasher is the Hasher that's used unless another Hasher is configured.
+// It hashes the left and right hashes with SHA512/256.
+type DefaultHasher struct{}
+
+// ParentHash returns the SHA512/256 hash of the left and right hashes.
+func (DefaultHasher) ParentHash(left, right Hash) Hash {
+	return parentHash(left, right)
+}
+
 func parentHash(l, r Hash) Hash {
 	h := sha512.New512_256()
 	h.Write(l[:])
@@ -82,6 +99,36 @@ func rootPosition(leaves uint64, h, forestRows uint8) uint64 {
 	return shifted & mask
 }
 
+// RootPositions returns the positions of all the roots in a forest with the given
+// numLeaves and forestRows. The positions are ordered from the highest root to the
+// lowest root, same as the roots returned by GetRoots and the roots in a Stump.
+func RootPositions(numLeaves uint64, forestRows uint8) []uint64 {
+	positions := make([]uint64, 0, numRoots(numLeaves))
+	for row := int(forestRows); row >= 0; row-- {
+		if numLeaves&(1<<row) == 0 {
+			continue
+		}
+		positions = append(positions, rootPosition(numLeaves, uint8(row), forestRows))
+	}
+
+	return positions
+}
+
+// SubtreeSizes returns the number of leaves under each root for the given numLeaves.
+// The sizes are ordered from the highest root to the lowest root, same as the
+// positions returned by RootPositions.
+func SubtreeSizes(numLeaves uint64) []uint64 {
+	sizes := make([]uint64, 0, numRoots(numLeaves))
+	for row := 63; row >= 0; row-- {
+		if numLeaves&(1<<row) == 0 {
+			continue
+		}
+		sizes = append(sizes, 1<<row)
+	}
+
+	return sizes
+}
+
 // isRootPosition checks if the current position is a root given the number of
 // leaves and the enitre rows of the forest.
 func isRootPosition(position, numLeaves uint64, forestRows uint8) bool {
@@ -265,6 +312,10 @@ func detectOffset(position uint64, numLeaves uint64) (uint8, uint8, uint64, erro
 }
 
 // treeRows returns the number of rows given n leaves.
+//
+// NOTE The result is only valid for n up to MaxNumLeaves. The next power of 2
+// overflows for anything bigger and 0 is returned.
+//
 // Example: The below tree will return 2 as the forest will allocate enough for
 // 4 leaves.
 //
@@ -304,6 +355,21 @@ func treeRows(n uint64) uint8 {
 
 }
 
+// MaxNumLeaves is the most leaves that an accumulator is able to have. The
+// positions in a forest with more leaves than this don't fit in a uint64.
+const MaxNumLeaves = 1 << 63
+
+// checkNumLeaves returns an error if the position math isn't able to handle a
+// forest with numLeaves.
+func checkNumLeaves(numLeaves uint64) error {
+	if numLeaves > MaxNumLeaves {
+		return fmt.Errorf("%w. Have %d leaves but the most supported is %d",
+			ErrTooManyLeaves, numLeaves, uint64(MaxNumLeaves))
+	}
+
+	return nil
+}
+
 // logicalTreeRows returns the number of
 //
 // Example: The below tree will return 1 as the logical number of rows is 1 for this
@@ -323,6 +389,40 @@ func numRoots(numLeaves uint64) uint8 {
 	return uint8(bits.OnesCount64(numLeaves))
 }
 
+// CanonicalRootOrder returns the roots sorted in the canonical order, which is
+// by ascending root position. The roots passed in must be ordered the same way
+// GetRoots returns them, which is from the highest root to the lowest root.
+// nil is returned if the count of the roots doesn't match the numLeaves.
+//
+// Any commitment to the roots of the accumulator must use this ordering. Commitment
+// and Stump.Serialize both use it.
+func CanonicalRootOrder(roots []Hash, numLeaves uint64) []Hash {
+	if len(roots) != int(numRoots(numLeaves)) {
+		return nil
+	}
+
+	forestRows := treeRows(numLeaves)
+
+	// Attach the positions to the roots. The roots are ordered from the
+	// highest row to the lowest row.
+	hnp := make([]hashAndPos, 0, len(roots))
+	for row := int(forestRows); row >= 0; row-- {
+		if numLeaves&(1<<row) == 0 {
+			continue
+		}
+		pos := rootPosition(numLeaves, uint8(row), forestRows)
+		hnp = append(hnp, hashAndPos{roots[len(hnp)], pos})
+	}
+	sort.Slice(hnp, func(a, b int) bool { return hnp[a].pos < hnp[b].pos })
+
+	ordered := make([]Hash, len(hnp))
+	for i := range hnp {
+		ordered[i] = hnp[i].hash
+	}
+
+	return ordered
+}
+
 // maxLeafCount returns the maximum amount of leaves an accumulator of the
 // given forestRows can have.
 func maxLeafCount(forestRows uint8) uint64 {
@@ -335,6 +435,54 @@ func maxPosition(forestRows uint8) uint64 {
 	return uint64(2<<forestRows) - 1
 }
 
+// inForest returns true if there's a node at the position in a forest with
+// numLeaves.
+func inForest(position, numLeaves uint64, forestRows uint8) bool {
+	if numLeaves == 0 || position >= maxPosition(forestRows) {
+		return false
+	}
+	max, err := maxPositionAtRow(detectRow(position, forestRows), forestRows, numLeaves)
+	return err == nil && position <= max
+}
+
+// LeafIndexToPosition returns the position of the leaf that was added to the
+// accumulator at the given index. The bottom row of the forest is laid out in the
+// order the leaves were added so the position of the leaf is the same as the index.
+// An error is returned if the index is out of range for numLeaves.
+//
+// NOTE The returned position is where the leaf was added. Deleting leaves moves
+// the remaining leaves up the forest so a leaf may not be at this position anymore
+// once there are deletions.
+func LeafIndexToPosition(index, numLeaves uint64) (uint64, error) {
+	err := checkNumLeaves(numLeaves)
+	if err != nil {
+		return 0, fmt.Errorf("LeafIndexToPosition fail. Error: %w", err)
+	}
+	if index >= numLeaves {
+		return 0, fmt.Errorf("LeafIndexToPosition fail. Index %d is out of "+
+			"range for %d leaves", index, numLeaves)
+	}
+
+	return index, nil
+}
+
+// PositionToLeafIndex returns the index of the leaf at the given position. It's the
+// inverse of LeafIndexToPosition. An error is returned if the position isn't a leaf
+// on the bottom row of a forest with numLeaves.
+func PositionToLeafIndex(pos, numLeaves uint64) (uint64, error) {
+	err := checkNumLeaves(numLeaves)
+	if err != nil {
+		return 0, fmt.Errorf("PositionToLeafIndex fail. Error: %w", err)
+	}
+	forestRows := treeRows(numLeaves)
+	if !inForest(pos, numLeaves, forestRows) || detectRow(pos, forestRows) != 0 {
+		return 0, fmt.Errorf("PositionToLeafIndex fail. Position %d isn't a "+
+			"leaf on the bottom row for %d leaves", pos, numLeaves)
+	}
+
+	return pos, nil
+}
+
 // startPositionAtRow returns the smallest position an accumulator can have for the
 // requested row for the given numLeaves.
 func startPositionAtRow(row, forestRows uint8) uint64 {
@@ -345,6 +493,33 @@ func startPositionAtRow(row, forestRows uint8) uint64 {
 	return uint64(offset)
 }
 
+// translatePos returns the position in a forest of toRows for the given position
+// in a forest of fromRows. The row of the position and the offset within the row
+// stay the same.
+func translatePos(position uint64, fromRows, toRows uint8) uint64 {
+	row := detectRow(position, fromRows)
+	offset := position - startPositionAtRow(row, fromRows)
+	return startPositionAtRow(row, toRows) + offset
+}
+
+// TranslatePositions returns the positions in a forest of toRows for the given
+// positions in a forest of fromRows. The passed in positions are not modified.
+// When translating to a smaller forest, all the positions must exist in the
+// smaller forest.
+func TranslatePositions(positions []uint64, fromRows, toRows uint8) []uint64 {
+	translated := make([]uint64, len(positions))
+	if fromRows == toRows {
+		copy(translated, positions)
+		return translated
+	}
+
+	for i, pos := range positions {
+		translated[i] = translatePos(pos, fromRows, toRows)
+	}
+
+	return translated
+}
+
 // maxPositionAtRow returns the biggest position an accumulator can have for the
 // requested row for the given numLeaves.
 func maxPositionAtRow(row, forestRows uint8, numLeaves uint64) (uint64, error) {
@@ -407,6 +582,57 @@ func deTwin(dels []uint64, forestRows uint8) []uint64 {
 	return dels
 }
 
+// DeTwin returns the targets with every pair of siblings replaced by their parent
+// position. The replacing is repeated so siblings whose parents are also siblings
+// are replaced by their grandparent and so on. The returned positions are sorted.
+// The targets passed in don't need to be sorted and aren't modified.
+//
+// The hashes of the returned positions that aren't targets are calculated from
+// the targets so they never need to be requested as part of a proof.
+func DeTwin(targets []uint64, forestRows uint8) []uint64 {
+	return deTwin(sortedTargets(targets), forestRows)
+}
+
+// FreeTargets returns the sorted targets whose sibling is also a target. These
+// targets don't need their sibling hash in the proof as the sibling is already
+// being proven. These are the twins that deTwin would turn into their parent.
+//
+// Targets that aren't below the top root of a forest with forestRows are never
+// free as they don't have a sibling in the forest.
+func FreeTargets(targets []uint64, forestRows uint8) []uint64 {
+	sorted := sortedTargets(targets)
+	topRoot := rootPosition(1<<forestRows, forestRows, forestRows)
+
+	var free []uint64
+	for i := 0; i+1 < len(sorted) && sorted[i+1] < topRoot; i++ {
+		if rightSib(sorted[i]) == sorted[i+1] && sorted[i]&1 == 0 {
+			free = append(free, sorted[i], sorted[i+1])
+			i++
+		}
+	}
+
+	return free
+}
+
+// subtractSortedSlice returns the elements of a that aren't in b. Both a and b
+// must be sorted. The returned slice reuses the backing array of a.
+func subtractSortedSlice(a, b []uint64) []uint64 {
+	kept := a[:0]
+
+	bIdx := 0
+	for _, elem := range a {
+		for bIdx < len(b) && b[bIdx] < elem {
+			bIdx++
+		}
+		if bIdx < len(b) && b[bIdx] == elem {
+			continue
+		}
+		kept = append(kept, elem)
+	}
+
+	return kept
+}
+
 func insertInOrder(dels []uint64, el uint64) []uint64 {
 	index := sort.Search(len(dels), func(i int) bool { return dels[i] > el })
 	dels = append(dels, 0)
@@ -418,7 +644,7 @@ func insertInOrder(dels []uint64, el uint64) []uint64 {
 
 // extractRow extracts and returns the targets at the requested row.
 func extractRow(targets []uint64, forestRows, rowToExtract uint8) []uint64 {
-	if len(targets) < 0 {
+	if len(targets) == 0 {
 		return []uint64{}
 	}
 
@@ -454,6 +680,108 @@ func extractRow(targets []uint64, forestRows, rowToExtract uint8) []uint64 {
 	return row
 }
 
+// ProofSize returns how many proof hashes a proof for the targets would have.
+// Targets that are roots don't need any proof hashes and proof hashes shared by
+// targets are only counted once.
+func ProofSize(numLeaves uint64, targets []uint64) int {
+	sorted := slices.Compact(sortedTargets(targets))
+	positions, _ := proofPositions(sorted, numLeaves, treeRows(numLeaves))
+	return len(positions)
+}
+
+// MaxProofDepth returns the most rows that any of the targets have to be hashed
+// up through to reach its root. Targets that are roots have a depth of 0. Targets
+// that don't exist in a forest with numLeaves are ignored.
+func MaxProofDepth(numLeaves uint64, targets []uint64) uint8 {
+	forestRows := treeRows(numLeaves)
+
+	var maxDepth uint8
+	for _, target := range targets {
+		if !inForest(target, numLeaves, forestRows) {
+			continue
+		}
+		_, depth, _, err := detectOffset(target, numLeaves)
+		if err != nil {
+			continue
+		}
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+
+	return maxDepth
+}
+
+// CommonProofPositions returns the sorted proof positions that every one of the
+// targets would need if it were proven on its own.
+func CommonProofPositions(numLeaves uint64, targets []uint64) []uint64 {
+	if len(targets) == 0 {
+		return nil
+	}
+	forestRows := treeRows(numLeaves)
+
+	common, _ := proofPositions([]uint64{targets[0]}, numLeaves, forestRows)
+	for _, target := range targets[1:] {
+		positions, _ := proofPositions([]uint64{target}, numLeaves, forestRows)
+
+		kept := common[:0]
+		for _, pos := range common {
+			if slices.Contains(positions, pos) {
+				kept = append(kept, pos)
+			}
+		}
+		common = kept
+	}
+	if len(common) == 0 {
+		return nil
+	}
+	slices.Sort(common)
+
+	return common
+}
+
+// SharedProofPositions returns the sorted proof positions that are needed by both
+// the proof for targetsA and the proof for targetsB. The targets don't need to be
+// sorted and aren't modified.
+func SharedProofPositions(numLeaves uint64, targetsA, targetsB []uint64) []uint64 {
+	forestRows := treeRows(numLeaves)
+	a, _ := proofPositions(slices.Compact(sortedTargets(targetsA)), numLeaves, forestRows)
+	b, _ := proofPositions(slices.Compact(sortedTargets(targetsB)), numLeaves, forestRows)
+
+	// The proof positions are already sorted since they're ordered by row
+	// and the positions on a higher row are always greater.
+	var shared []uint64
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			shared = append(shared, a[i])
+			i++
+			j++
+		}
+	}
+
+	return shared
+}
+
+// TreeRows returns the number of rows the forest has for numLeaves leaves.
+func TreeRows(numLeaves uint64) uint8 {
+	return treeRows(numLeaves)
+}
+
+// ProofPositions returns the positions of the proof hashes that are needed to prove
+// the targets along with the computable positions. The proof positions are in the
+// same order as the proof hashes in a Proof. The computable positions are the
+// positions that are calculated by hashing up from the targets and don't need to
+// be included in the proof. The targets don't need to be sorted and aren't
+// modified.
+func ProofPositions(targets []uint64, numLeaves uint64, forestRows uint8) (proof []uint64, computable []uint64) {
+	return proofPositions(sortedTargets(targets), numLeaves, forestRows)
+}
+
 // proofPositions returns all the positions that are needed to prove targets passed in.
 func proofPositions(targets []uint64, numLeaves uint64, forestRows uint8) ([]uint64, []uint64) {
 	var nextTargets, proofPositions, computedPositions []uint64
@@ -541,6 +869,23 @@ func proofPositions(targets []uint64, numLeaves uint64, forestRows uint8) ([]uin
 	return proofPositions, computedPositions
 }
 
+// ProofPosition returns the positions of the proof hashes that are needed to prove
+// the single position passed in. The positions are ordered from the bottom row up,
+// the same as they'd be in a Proof for just this position. Nil is returned if the
+// position is a root.
+func ProofPosition(position, numLeaves uint64, forestRows uint8) []uint64 {
+	var proof []uint64
+	for row := detectRow(position, forestRows); row < forestRows; row++ {
+		if isRootPosition(position, numLeaves, forestRows) {
+			break
+		}
+		proof = append(proof, sibling(position))
+		position = parent(position, forestRows)
+	}
+
+	return proof
+}
+
 // String prints out the whole thing. Only viable for forest that have height of 5 and less.
 func (p *Pollard) String() string {
 	fh := treeRows(p.numLeaves)
@@ -609,6 +954,51 @@ func (p *Pollard) String() string {
 
 }
 
+// DotString returns the forest in the graphviz DOT format. Each node is labeled
+// with its position and points to its children. Nodes that are cached in the
+// pollard are filled in and nodes that were pruned are dashed.
+func (p *Pollard) DotString() string {
+	var sb strings.Builder
+	sb.WriteString("digraph forest {\n")
+	sb.WriteString("\tnode [shape=box];\n")
+
+	forestRows := treeRows(p.numLeaves)
+	for row := int(forestRows); row >= 0; row-- {
+		if p.numLeaves&(1<<row) == 0 {
+			continue
+		}
+		rootPos := rootPosition(p.numLeaves, uint8(row), forestRows)
+		if p.getHash(rootPos) == empty {
+			continue
+		}
+		p.writeDotNode(&sb, rootPos, forestRows)
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// writeDotNode writes the node at the given position and its descendants to the
+// builder. The children of pruned nodes are not written.
+func (p *Pollard) writeDotNode(sb *strings.Builder, pos uint64, forestRows uint8) {
+	hash := p.getHash(pos)
+	if hash == empty {
+		fmt.Fprintf(sb, "\t%d [label=\"%d\", style=dashed, color=gray];\n", pos, pos)
+		return
+	}
+	fmt.Fprintf(sb, "\t%d [label=\"%d\\n%x\", style=filled, fillcolor=lightblue];\n",
+		pos, pos, hash[:4])
+
+	if detectRow(pos, forestRows) == 0 {
+		return
+	}
+
+	for _, child := range []uint64{leftChild(pos, forestRows), rightChild(pos, forestRows)} {
+		fmt.Fprintf(sb, "\t%d -> %d;\n", pos, child)
+		p.writeDotNode(sb, child, forestRows)
+	}
+}
+
 // getRootPosition returns the root of the subtree that this position is included in.
 func getRootPosition(position uint64, numLeaves uint64, forestRows uint8) (uint64, error) {
 	returnPos := position
@@ -1,8 +1,12 @@
 package utreexo
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"reflect"
+	"strings"
 	"sort"
 	"testing"
 	"time"
@@ -44,6 +48,40 @@ func TestDeTwin(t *testing.T) {
 	}
 }
 
+func TestDeTwinExported(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		forestRows uint8
+		targets    []uint64
+		expected   []uint64
+	}{
+		// Two sibling pairs whose parents are also siblings.
+		{3, []uint64{3, 0, 2, 1}, []uint64{12}},
+		// The whole forest collapses into the root.
+		{3, []uint64{7, 6, 5, 4, 3, 2, 1, 0}, []uint64{14}},
+		// Cascades up from row 0 and joins a target already at row 1.
+		{3, []uint64{9, 1, 0, 4}, []uint64{4, 12}},
+		{4, []uint64{20, 3, 0, 2, 1}, []uint64{20, 24}},
+		{3, []uint64{0, 2, 5}, []uint64{0, 2, 5}},
+	}
+
+	for _, test := range tests {
+		targets := make([]uint64, len(test.targets))
+		copy(targets, test.targets)
+
+		got := DeTwin(targets, test.forestRows)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("TestDeTwinExported fail for %v. Expected %v, got %v",
+				test.targets, test.expected, got)
+		}
+		if !reflect.DeepEqual(targets, test.targets) {
+			t.Fatalf("TestDeTwinExported fail. Targets were modified from %v to %v",
+				test.targets, targets)
+		}
+	}
+}
+
 func TestDeTwinRand(t *testing.T) {
 	t.Parallel()
 
@@ -90,3 +128,645 @@ func TestDeTwinRand(t *testing.T) {
 		}
 	}
 }
+
+func TestCanonicalRootOrder(t *testing.T) {
+	t.Parallel()
+
+	for numLeaves := uint64(1); numLeaves < 100; numLeaves++ {
+		p := NewAccumulator(true)
+		adds, _, _ := getAddsAndDels(0, uint32(numLeaves), 0)
+		err := p.Modify(adds, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		roots := p.GetRoots()
+		ordered := CanonicalRootOrder(roots, numLeaves)
+		if len(ordered) != len(roots) {
+			t.Fatalf("TestCanonicalRootOrder fail: expected %d roots, got %d",
+				len(roots), len(ordered))
+		}
+
+		// The ordering should be stable across calls.
+		if !reflect.DeepEqual(ordered, CanonicalRootOrder(roots, numLeaves)) {
+			t.Fatalf("TestCanonicalRootOrder fail: ordering not stable for %d leaves",
+				numLeaves)
+		}
+
+		// Each of the roots should be at an ascending position.
+		forestRows := treeRows(numLeaves)
+		idx := 0
+		for row := uint8(0); row <= forestRows; row++ {
+			if numLeaves&(1<<row) == 0 {
+				continue
+			}
+			pos := rootPosition(numLeaves, row, forestRows)
+			if ordered[idx] != p.getHash(pos) {
+				t.Fatalf("TestCanonicalRootOrder fail: root %d isn't the root at position %d",
+					idx, pos)
+			}
+			idx++
+		}
+	}
+
+	if CanonicalRootOrder([]Hash{{1}}, 3) != nil {
+		t.Fatalf("TestCanonicalRootOrder fail: expected nil for mismatched root count")
+	}
+}
+
+func TestFreeTargets(t *testing.T) {
+	t.Parallel()
+
+	// 30
+	// |-------------------------------\
+	// 28                              29
+	// |---------------\               |---------------\
+	// 24              25              26              27
+	// |-------\       |-------\       |-------\       |-------\
+	// 16      17      18      19      20      21      22      23
+	// |---\   |---\   |---\   |---\   |---\   |---\   |---\   |---\
+	// 00  01  02  03  04  05  06  07  08  09  10  11  12  13  14  15
+	tests := []struct {
+		targets  []uint64
+		expected []uint64
+	}{
+		{[]uint64{}, nil},
+		{[]uint64{0}, nil},
+		{[]uint64{1, 2}, nil},
+		{[]uint64{0, 1}, []uint64{0, 1}},
+		{[]uint64{13, 0, 12, 5, 1, 17, 16, 20, 25, 24}, []uint64{0, 1, 12, 13, 16, 17, 24, 25}},
+		{[]uint64{3, 4, 7, 8, 21, 22}, nil},
+		{[]uint64{28, 29, 30, 31}, []uint64{28, 29}},
+		{[]uint64{30, 31}, nil},
+		{[]uint64{32, 33}, nil},
+	}
+
+	for _, test := range tests {
+		got := FreeTargets(test.targets, 4)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("TestFreeTargets fail. For targets %v expected %v, got %v",
+				test.targets, test.expected, got)
+		}
+	}
+}
+
+func TestSubtractSortedSlice(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b     []uint64
+		expected []uint64
+	}{
+		{[]uint64{}, []uint64{1}, []uint64{}},
+		{[]uint64{1, 2, 3}, []uint64{}, []uint64{1, 2, 3}},
+		{[]uint64{1, 2, 3}, []uint64{1, 2, 3}, []uint64{}},
+		{[]uint64{1, 3, 5, 7, 9}, []uint64{0, 3, 4, 9, 10}, []uint64{1, 5, 7}},
+		{[]uint64{2, 2, 4, 6}, []uint64{2, 6}, []uint64{4}},
+	}
+
+	for _, test := range tests {
+		a := make([]uint64, len(test.a))
+		copy(a, test.a)
+
+		got := subtractSortedSlice(a, test.b)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("TestSubtractSortedSlice fail. %v - %v expected %v, got %v",
+				test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func BenchmarkSubtractSortedSlice(b *testing.B) {
+	orig := make([]uint64, 10_000)
+	for i := range orig {
+		orig[i] = uint64(i)
+	}
+	remove := make([]uint64, 0, len(orig)/2)
+	for i := 0; i < len(orig); i += 2 {
+		remove = append(remove, uint64(i))
+	}
+
+	a := make([]uint64, len(orig))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(a, orig)
+		subtractSortedSlice(a, remove)
+	}
+}
+
+func TestDotString(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves, _, _ := getAddsAndDels(uint32(p.numLeaves), 7, 0)
+	err := p.Modify(leaves, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 12
+	// |-------\
+	// 08      09      10
+	// |---\   |---\   |---\
+	// 00  01  02  03  04  05  06
+	//
+	// Prune 02 and 03 by removing the nieces of 08.
+	node, _, _, err := p.getNode(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.lNiece, node.rNiece = nil, nil
+
+	dot := p.DotString()
+	if !strings.HasPrefix(dot, "digraph forest {") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("TestDotString fail. Not a digraph:\n%s", dot)
+	}
+	for _, expected := range []string{
+		"\t12 -> 8;\n", "\t12 -> 9;\n", "\t8 -> 1;\n", "\t10 -> 5;\n",
+		"\t9 -> 2;\n", "\t2 [label=\"2\", style=dashed", "\t3 [label=\"3\", style=dashed",
+		"\t6 [label=\"6\\n", "\t8 [label=\"8\\n",
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Fatalf("TestDotString fail. Expected %q in:\n%s", expected, dot)
+		}
+	}
+	if strings.Contains(dot, "\t6 -> ") {
+		t.Fatalf("TestDotString fail. Expected no children for a leaf:\n%s", dot)
+	}
+}
+
+func TestCommonProofPositions(t *testing.T) {
+	t.Parallel()
+
+	// 30
+	// |-------------------------------\
+	// 28                              29
+	// |---------------\               |---------------\
+	// 24              25              26              27
+	// |-------\       |-------\       |-------\       |-------\
+	// 16      17      18      19      20      21      22      23
+	// |---\   |---\   |---\   |---\   |---\   |---\   |---\   |---\
+	// 00  01  02  03  04  05  06  07  08  09  10  11  12  13  14  15
+	tests := []struct {
+		targets  []uint64
+		expected []uint64
+	}{
+		{nil, nil},
+		{[]uint64{5}, []uint64{4, 19, 24, 29}},
+		{[]uint64{0, 1}, []uint64{17, 25, 29}},
+		{[]uint64{0, 3}, []uint64{25, 29}},
+		{[]uint64{0, 7, 2}, []uint64{29}},
+		{[]uint64{0, 15}, nil},
+		{[]uint64{1, 6, 9, 14}, nil},
+	}
+
+	for _, test := range tests {
+		got := CommonProofPositions(16, test.targets)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("TestCommonProofPositions fail. For targets %v expected %v, got %v",
+				test.targets, test.expected, got)
+		}
+	}
+}
+
+func TestMaxProofDepth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		numLeaves uint64
+		targets   []uint64
+		expected  uint8
+	}{
+		// 20 leaves make a tree of 16 leaves and a tree of 4 leaves.
+		{20, nil, 0},
+		{20, []uint64{3}, 4},
+		{20, []uint64{17}, 2},
+		{20, []uint64{19, 17}, 2},
+		{20, []uint64{17, 3}, 4},
+		// 32 is the parent of 0 and 1.
+		{20, []uint64{32}, 3},
+		{20, []uint64{32, 18}, 3},
+		// The roots.
+		{20, []uint64{RootPositions(20, 5)[0], RootPositions(20, 5)[1]}, 0},
+		// 20 is a lone leaf that's also a root.
+		{21, []uint64{20}, 0},
+		{21, []uint64{20, 17}, 2},
+		// Out of range targets are ignored.
+		{20, []uint64{20, 100}, 0},
+	}
+
+	for _, test := range tests {
+		got := MaxProofDepth(test.numLeaves, test.targets)
+		if got != test.expected {
+			t.Fatalf("TestMaxProofDepth fail. For targets %v with %d leaves "+
+				"expected %d, got %d", test.targets, test.numLeaves, test.expected, got)
+		}
+	}
+}
+
+func TestSharedProofPositions(t *testing.T) {
+	t.Parallel()
+
+	// 30
+	// |-------------------------------\
+	// 28                              29
+	// |---------------\               |---------------\
+	// 24              25              26              27
+	// |-------\       |-------\       |-------\       |-------\
+	// 16      17      18      19      20      21      22      23
+	// |---\   |---\   |---\   |---\   |---\   |---\   |---\   |---\
+	// 00  01  02  03  04  05  06  07  08  09  10  11  12  13  14  15
+	tests := []struct {
+		targetsA []uint64
+		targetsB []uint64
+		expected []uint64
+	}{
+		// Disjoint.
+		{nil, []uint64{5}, nil},
+		{[]uint64{0}, []uint64{15}, nil},
+
+		// Partially overlapping.
+		{[]uint64{0}, []uint64{2}, []uint64{25, 29}},
+		{[]uint64{0}, []uint64{1}, []uint64{17, 25, 29}},
+		{[]uint64{0, 1}, []uint64{5}, []uint64{29}},
+
+		// Identical.
+		{[]uint64{5, 0}, []uint64{0, 5}, []uint64{1, 4, 17, 19, 29}},
+	}
+
+	for _, test := range tests {
+		targetsA := make([]uint64, len(test.targetsA))
+		copy(targetsA, test.targetsA)
+		targetsB := make([]uint64, len(test.targetsB))
+		copy(targetsB, test.targetsB)
+
+		got := SharedProofPositions(16, targetsA, targetsB)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("TestSharedProofPositions fail. For targets %v and %v "+
+				"expected %v, got %v", test.targetsA, test.targetsB, test.expected, got)
+		}
+
+		// The result shouldn't depend on the order of the target sets.
+		got = SharedProofPositions(16, targetsB, targetsA)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("TestSharedProofPositions fail. For targets %v and %v "+
+				"expected %v, got %v", test.targetsB, test.targetsA, test.expected, got)
+		}
+
+		if !slices.Equal(targetsA, test.targetsA) || !slices.Equal(targetsB, test.targetsB) {
+			t.Fatalf("TestSharedProofPositions fail. Targets were modified")
+		}
+	}
+}
+
+func TestProofSize(t *testing.T) {
+	t.Parallel()
+
+	// 12
+	// |-------\
+	// 08      09      10
+	// |---\   |---\   |---\
+	// 00  01  02  03  04  05  06
+	tests := []struct {
+		targets  []uint64
+		expected int
+	}{
+		{nil, 0},
+		{[]uint64{6}, 0},
+		{[]uint64{12}, 0},
+		{[]uint64{6, 12}, 0},
+		{[]uint64{0}, 2},
+		{[]uint64{0, 1}, 1},
+		{[]uint64{1, 0, 0}, 1},
+		{[]uint64{0, 2}, 2},
+		{[]uint64{0, 4, 6}, 3},
+		{[]uint64{0, 1, 2, 3}, 0},
+	}
+
+	for _, test := range tests {
+		got := ProofSize(7, test.targets)
+		if got != test.expected {
+			t.Fatalf("TestProofSize fail. For targets %v expected %d, got %d",
+				test.targets, test.expected, got)
+		}
+	}
+
+	// Should match the size of actual proofs.
+	sc := newSimChainWithSeed(0x0f, 0)
+	p := NewAccumulator(true)
+	for b := 0; b <= 30; b++ {
+		adds, _, delHashes := sc.NextBlock(9)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatalf("TestProofSize fail at block %d. Error: %v", b, err)
+		}
+		got := ProofSize(p.numLeaves, proof.Targets)
+		if got != len(proof.Proof) {
+			t.Fatalf("TestProofSize fail at block %d. Expected %d, got %d",
+				b, len(proof.Proof), got)
+		}
+
+		err = p.Modify(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatalf("TestProofSize fail at block %d. Error: %v", b, err)
+		}
+	}
+}
+
+func TestTranslatePositions(t *testing.T) {
+	t.Parallel()
+
+	positions := []uint64{3, 8, 11, 12, 14}
+
+	same := TranslatePositions(positions, 3, 3)
+	if !reflect.DeepEqual(same, positions) {
+		t.Fatalf("TestTranslatePositions fail. Expected %v, got %v", positions, same)
+	}
+	same[0] = 100
+	if positions[0] != 3 {
+		t.Fatalf("TestTranslatePositions fail. The passed in positions were modified")
+	}
+
+	expected := []uint64{3, 16, 19, 24, 28}
+	up := TranslatePositions(positions, 3, 4)
+	if !reflect.DeepEqual(up, expected) {
+		t.Fatalf("TestTranslatePositions fail. Expected %v, got %v", expected, up)
+	}
+	for i, pos := range positions {
+		if up[i] != translatePos(pos, 3, 4) {
+			t.Fatalf("TestTranslatePositions fail. Expected %d, got %d",
+				translatePos(pos, 3, 4), up[i])
+		}
+	}
+
+	down := TranslatePositions(up, 4, 3)
+	if !reflect.DeepEqual(down, positions) {
+		t.Fatalf("TestTranslatePositions fail. Expected %v, got %v", positions, down)
+	}
+}
+
+func TestSubtreeSizes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		numLeaves uint64
+		expected  []uint64
+	}{
+		{0, []uint64{}},
+		{1, []uint64{1}},
+		{8, []uint64{8}},
+		{13, []uint64{8, 4, 1}},
+		{15, []uint64{8, 4, 2, 1}},
+		{1 << 63, []uint64{1 << 63}},
+		{1<<63 + 5, []uint64{1 << 63, 4, 1}},
+	}
+
+	for _, test := range tests {
+		sizes := SubtreeSizes(test.numLeaves)
+		if !reflect.DeepEqual(sizes, test.expected) {
+			t.Fatalf("TestSubtreeSizes fail for %d leaves. Expected %v, got %v",
+				test.numLeaves, test.expected, sizes)
+		}
+
+		sum := uint64(0)
+		for _, size := range sizes {
+			sum += size
+		}
+		if sum != test.numLeaves {
+			t.Fatalf("TestSubtreeSizes fail for %d leaves. Sizes add up to %d",
+				test.numLeaves, sum)
+		}
+
+		if test.numLeaves < 1<<32 {
+			positions := RootPositions(test.numLeaves, treeRows(test.numLeaves))
+			if len(positions) != len(sizes) {
+				t.Fatalf("TestSubtreeSizes fail for %d leaves. Have %d root "+
+					"positions but %d sizes", test.numLeaves, len(positions), len(sizes))
+			}
+		}
+	}
+}
+
+func TestProofPosition(t *testing.T) {
+	t.Parallel()
+
+	// 14
+	// |---------------\
+	// 12              13
+	// |-------\       |-------\
+	// 08      09      10      11
+	// |---\   |---\   |---\   |---\
+	// 00  01  02  03  04  05  06  07
+	tests := []struct {
+		position  uint64
+		numLeaves uint64
+		expected  []uint64
+	}{
+		{0, 8, []uint64{1, 9, 13}},
+		{5, 8, []uint64{4, 11, 12}},
+		{12, 8, []uint64{13}},
+		{14, 8, nil},
+		{4, 5, nil},
+		{6, 7, nil},
+		{2, 7, []uint64{3, 8}},
+	}
+
+	for _, test := range tests {
+		got := ProofPosition(test.position, test.numLeaves, 3)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("TestProofPosition fail for position %d with %d leaves. "+
+				"Expected %v, got %v", test.position, test.numLeaves, test.expected, got)
+		}
+	}
+
+	// Compare against proofPositions for every position of several forest shapes.
+	for numLeaves := uint64(1); numLeaves <= 33; numLeaves++ {
+		forestRows := treeRows(numLeaves)
+		for row := uint8(0); row <= forestRows; row++ {
+			start := startPositionAtRow(row, forestRows)
+			max, err := maxPositionAtRow(row, forestRows, numLeaves)
+			if err != nil {
+				continue
+			}
+			for pos := start; pos <= max; pos++ {
+				expected, _ := proofPositions([]uint64{pos}, numLeaves, forestRows)
+				got := ProofPosition(pos, numLeaves, forestRows)
+				if len(expected) == 0 && len(got) == 0 {
+					continue
+				}
+				if !reflect.DeepEqual(got, expected) {
+					t.Fatalf("TestProofPosition fail for position %d with %d leaves. "+
+						"Expected %v, got %v", pos, numLeaves, expected, got)
+				}
+			}
+		}
+	}
+}
+
+func TestProofPositions(t *testing.T) {
+	t.Parallel()
+
+	// 14
+	// |---------------\
+	// 12              13
+	// |-------\       |-------\
+	// 08      09      10      11
+	// |---\   |---\   |---\   |---\
+	// 00  01  02  03  04  05  06  07
+	tests := []struct {
+		targets    []uint64
+		proof      []uint64
+		computable []uint64
+	}{
+		{[]uint64{0}, []uint64{1, 9, 13}, []uint64{8, 12}},
+		{[]uint64{0, 1}, []uint64{9, 13}, []uint64{8, 12}},
+		{[]uint64{5, 0}, []uint64{1, 4, 9, 11}, []uint64{8, 10, 12, 13}},
+		{[]uint64{12}, []uint64{13}, nil},
+	}
+
+	for _, test := range tests {
+		targets := make([]uint64, len(test.targets))
+		copy(targets, test.targets)
+
+		proof, computable := ProofPositions(targets, 8, 3)
+		if !reflect.DeepEqual(proof, test.proof) {
+			t.Fatalf("TestProofPositions fail for targets %v. Expected proof "+
+				"positions %v, got %v", test.targets, test.proof, proof)
+		}
+		if !reflect.DeepEqual(computable, test.computable) {
+			t.Fatalf("TestProofPositions fail for targets %v. Expected computable "+
+				"positions %v, got %v", test.targets, test.computable, computable)
+		}
+		if !reflect.DeepEqual(targets, test.targets) {
+			t.Fatalf("TestProofPositions fail. Targets were modified from %v to %v",
+				test.targets, targets)
+		}
+	}
+}
+
+func TestLargeNumLeaves(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		numLeaves uint64
+		rows      uint8
+		roots     []uint64
+	}{
+		{1 << 62, 62, []uint64{1<<63 - 2}},
+		{1<<63 - 1, 63, nil},
+		{1 << 63, 63, []uint64{math.MaxUint64 - 1}},
+	}
+
+	for _, test := range tests {
+		err := checkNumLeaves(test.numLeaves)
+		if err != nil {
+			t.Fatalf("TestLargeNumLeaves fail. Error: %v", err)
+		}
+
+		rows := treeRows(test.numLeaves)
+		if rows != test.rows {
+			t.Fatalf("TestLargeNumLeaves fail. Expected %d rows for %d leaves, got %d",
+				test.rows, test.numLeaves, rows)
+		}
+
+		roots := RootPositions(test.numLeaves, rows)
+		if len(roots) != int(numRoots(test.numLeaves)) {
+			t.Fatalf("TestLargeNumLeaves fail. Expected %d roots for %d leaves, got %d",
+				numRoots(test.numLeaves), test.numLeaves, len(roots))
+		}
+		if test.roots != nil && !reflect.DeepEqual(roots, test.roots) {
+			t.Fatalf("TestLargeNumLeaves fail. Expected roots %v for %d leaves, got %v",
+				test.roots, test.numLeaves, roots)
+		}
+
+		// The highest root must be the ancestor of the first leaf and the
+		// lowest root must be at or above the last leaf.
+		top, err := parentMany(0, detectRow(roots[0], rows), rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if top != roots[0] {
+			t.Fatalf("TestLargeNumLeaves fail. Expected %d as the ancestor of "+
+				"leaf 0, got %d", roots[0], top)
+		}
+		lastLeaf := test.numLeaves - 1
+		bottom, err := parentMany(lastLeaf, detectRow(roots[len(roots)-1], rows), rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bottom != roots[len(roots)-1] {
+			t.Fatalf("TestLargeNumLeaves fail. Expected %d as the ancestor of "+
+				"leaf %d, got %d", roots[len(roots)-1], lastLeaf, bottom)
+		}
+
+		proof := ProofPosition(0, test.numLeaves, rows)
+		if len(proof) != int(detectRow(roots[0], rows)) {
+			t.Fatalf("TestLargeNumLeaves fail. Expected %d proof positions for "+
+				"leaf 0, got %d", detectRow(roots[0], rows), len(proof))
+		}
+		if parent(proof[len(proof)-1], rows) != roots[0] {
+			t.Fatalf("TestLargeNumLeaves fail. Last proof position %d isn't "+
+				"a child of the root %d", proof[len(proof)-1], roots[0])
+		}
+
+		tree, _, _, err := detectOffset(lastLeaf, test.numLeaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(tree) != len(roots)-1 {
+			t.Fatalf("TestLargeNumLeaves fail. Expected leaf %d to be under "+
+				"root %d, got %d", lastLeaf, len(roots)-1, tree)
+		}
+	}
+
+	for _, numLeaves := range []uint64{1<<63 + 1, math.MaxUint64} {
+		err := checkNumLeaves(numLeaves)
+		if !errors.Is(err, ErrTooManyLeaves) {
+			t.Fatalf("TestLargeNumLeaves fail. Expected %v for %d leaves, got %v",
+				ErrTooManyLeaves, numLeaves, err)
+		}
+	}
+}
+
+func TestLeafIndexToPosition(t *testing.T) {
+	t.Parallel()
+
+	for _, numLeaves := range []uint64{1, 2, 3, 5, 8, 13, 16, 31, 100} {
+		forestRows := treeRows(numLeaves)
+		for index := uint64(0); index < numLeaves; index++ {
+			pos, err := LeafIndexToPosition(index, numLeaves)
+			if err != nil {
+				t.Fatalf("TestLeafIndexToPosition fail. Error: %v", err)
+			}
+			if detectRow(pos, forestRows) != 0 {
+				t.Fatalf("TestLeafIndexToPosition fail. Position %d for index %d "+
+					"isn't on the bottom row", pos, index)
+			}
+
+			got, err := PositionToLeafIndex(pos, numLeaves)
+			if err != nil {
+				t.Fatalf("TestLeafIndexToPosition fail. Error: %v", err)
+			}
+			if got != index {
+				t.Fatalf("TestLeafIndexToPosition fail. Expected index %d for "+
+					"position %d, got %d", index, pos, got)
+			}
+		}
+
+		_, err := LeafIndexToPosition(numLeaves, numLeaves)
+		if err == nil {
+			t.Fatalf("TestLeafIndexToPosition fail. Expected an error for index %d "+
+				"with %d leaves", numLeaves, numLeaves)
+		}
+
+		// Positions past the last leaf and positions above the bottom row
+		// aren't leaves.
+		for pos := numLeaves; pos < maxPosition(forestRows); pos++ {
+			_, err := PositionToLeafIndex(pos, numLeaves)
+			if err == nil {
+				t.Fatalf("TestLeafIndexToPosition fail. Expected an error for "+
+					"position %d with %d leaves", pos, numLeaves)
+			}
+		}
+	}
+}